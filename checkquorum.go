@@ -0,0 +1,38 @@
+package raft
+
+import "time"
+
+// CheckQuorum: a leader that hasn't heard back from a majority within
+// an election timeout has most likely been partitioned away, and any
+// entry it accepts in Start() can never commit. It steps down instead,
+// so GetState() and Start() stop reporting it as leader.
+
+// Notes that server answered an RPC sent at sentAt in term.
+// Always call this while holding the raft lock.
+func (rf *Raft) recordAck(server int, term int, sentAt time.Time) {
+	if term == rf.currentTerm {
+		rf.lastAck[server] = rf.clock.Now()
+		if sentAt.After(rf.ackSent[server]) {
+			rf.ackSent[server] = sentAt
+		}
+	}
+}
+
+// Reverts to follower if the peers (counting ourselves) that have
+// answered within the last election timeout don't form a quorum of the
+// latest configuration.
+// Always call this while holding the raft lock.
+func (rf *Raft) checkQuorum() {
+	if rf.state != LeaderState {
+		return
+	}
+
+	inContact := func(server int) bool {
+		return server == rf.me || rf.clock.Now().Sub(rf.lastAck[server]) < rf.conf.MaxElectionTimeout
+	}
+
+	config, _ := rf.log.lastConfig()
+	if !config.isQuorum(inContact) {
+		rf.revertToFollower()
+	}
+}
@@ -0,0 +1,43 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+// A leader cut off from the others must notice within an election
+// timeout, or so, and stop claiming to lead.
+func TestCheckQuorumStepDown(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	rf := c.rafts[leader]
+	c.disconnect(leader)
+
+	// The last ack may be a heartbeat old, and the check only runs on
+	// the next heartbeat after that.
+	conf := DefaultConfig()
+	deadline := conf.MaxElectionTimeout + 3*conf.HeartbeatInterval
+	start := time.Now()
+	for {
+		if _, isLeader := rf.GetState(); !isLeader {
+			break
+		}
+		if time.Since(start) > deadline {
+			t.Fatalf("isolated leader still leads %v after losing contact", time.Since(start))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, _, isLeader := rf.Start(2); isLeader {
+		t.Fatalf("Start on the isolated server says it is leader after it stepped down")
+	}
+
+	// The others go on without it, and it follows once it is back.
+	c.checkOneLeader()
+	c.one(3, 2, false)
+	c.connect(leader)
+	c.one(4, 3, true)
+}
@@ -0,0 +1,176 @@
+package raft
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is where Raft gets the time and waits for it to pass. Tests can
+// swap in a FakeClock to decide when timeouts fire, instead of racing
+// the real clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Clock backed by the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.ticker.C }
+func (t realTicker) Stop()               { t.ticker.Stop() }
+
+// A Clock that only moves when Advance is called. Timers, sleeps and
+// tickers due by the new time fire in order of their deadlines, each
+// seeing Now() at its own deadline.
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	// Zero for a one-shot timer
+	period time.Duration
+	c      chan time.Time
+	clock  *FakeClock
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).c
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("raft: non-positive interval for NewTicker")
+	}
+	return c.add(d, d)
+}
+
+func (c *FakeClock) add(d time.Duration, period time.Duration) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Buffered like the time package's, so firing never blocks Advance.
+	t := &fakeTimer{at: c.now.Add(d), period: period, c: make(chan time.Time, 1), clock: c}
+	if d <= 0 {
+		t.c <- c.now
+		return t
+	}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Moves the clock forward by d, firing everything that comes due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	end := c.now.Add(d)
+	for c.fire(end) {
+	}
+	c.now = end
+}
+
+// Fires the timer due first if it is due by end, moving the clock up to
+// it. Returns whether there was one. Lets the simulation wake waiters
+// one at a time.
+func (c *FakeClock) fireNext(end time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fire(end)
+}
+
+// Always call this while holding the clock lock.
+func (c *FakeClock) fire(end time.Time) bool {
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	if len(c.timers) == 0 || c.timers[0].at.After(end) {
+		return false
+	}
+	t := c.timers[0]
+	c.timers = c.timers[1:]
+	c.now = t.at
+	select {
+	case t.c <- t.at:
+	default:
+		// A ticker nobody is reading drops ticks, like time.Ticker.
+	}
+	if t.period > 0 {
+		t.at = t.at.Add(t.period)
+		c.timers = append(c.timers, t)
+	}
+	return true
+}
+
+// When the next timer, sleep or tick is due, if anything is waiting.
+func (c *FakeClock) next() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.timers) == 0 {
+		return time.Time{}, false
+	}
+	next := c.timers[0].at
+	for _, t := range c.timers[1:] {
+		if t.at.Before(next) {
+			next = t.at
+		}
+	}
+	return next, true
+}
+
+// How many timers, sleeps and tickers are waiting on the clock. Lets a
+// test wait for goroutines to block before advancing it.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() {
+	c := t.clock
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			break
+		}
+	}
+}
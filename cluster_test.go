@@ -0,0 +1,335 @@
+package raft
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"6.824/labgob"
+	"6.824/labrpc"
+)
+
+// A cluster of Raft peers on a labrpc network for tests. Peers can be
+// disconnected, crashed and restarted from what they persisted. Every
+// command applied by any peer is checked against what the others
+// applied at the same index, across restarts.
+type cluster struct {
+	t   *testing.T
+	mu  sync.Mutex
+	n   int
+	net *labrpc.Network
+
+	rafts      []*Raft
+	persisters []*Persister
+	connected  []bool
+	// endnames[i][j] is the end peer i uses to reach peer j
+	endnames [][]string
+	// Commands applied by each peer since it last started, by index
+	applied []map[int]interface{}
+	// Every command applied by any peer, by index
+	committed map[int]interface{}
+	// If set, each peer snapshots the commands it has applied every
+	// snapshotInterval indices, and gets them back from its snapshot
+	// when it restarts.
+	snapshotInterval int
+	// Snapshots from a leader each peer installed since it last started
+	installed []int
+
+	// Makes peer i. Defaults to Make, tests can swap in other options.
+	makePeer func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft
+	// Called by peer i's applier for each command, without the cluster lock
+	onApply func(i int, msg ApplyMsg)
+}
+
+func makeCluster(t *testing.T, n int) *cluster {
+	c := &cluster{
+		t:          t,
+		n:          n,
+		net:        labrpc.MakeNetwork(),
+		rafts:      make([]*Raft, n),
+		persisters: make([]*Persister, n),
+		connected:  make([]bool, n),
+		endnames:   make([][]string, n),
+		applied:    make([]map[int]interface{}, n),
+		committed:  map[int]interface{}{},
+		installed:  make([]int, n),
+		makePeer: func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+			return Make(ends, i, persister, applyCh)
+		},
+	}
+	for i := 0; i < n; i++ {
+		c.persisters[i] = MakePersister()
+	}
+	return c
+}
+
+// Starts every peer and connects them.
+func (c *cluster) begin() {
+	for i := 0; i < c.n; i++ {
+		c.start(i)
+	}
+	for i := 0; i < c.n; i++ {
+		c.connect(i)
+	}
+}
+
+// Starts peer i from whatever it persisted, with fresh end points so
+// nothing sent to its previous incarnation gets through. It stays
+// disconnected until connect(i).
+func (c *cluster) start(i int) {
+	c.crash(i)
+
+	c.endnames[i] = make([]string, c.n)
+	ends := make([]*labrpc.ClientEnd, c.n)
+	for j := 0; j < c.n; j++ {
+		c.endnames[i][j] = fmt.Sprintf("%v-%v-%v", i, j, time.Now().UnixNano())
+		ends[j] = c.net.MakeEnd(c.endnames[i][j])
+		c.net.Connect(c.endnames[i][j], fmt.Sprint(j))
+	}
+
+	c.mu.Lock()
+	// A copy, so a killed incarnation still writing can't touch it.
+	c.persisters[i] = c.persisters[i].Copy()
+	persister := c.persisters[i]
+	c.applied[i] = map[int]interface{}{}
+	c.installed[i] = 0
+	if persister.SnapshotSize() > 0 {
+		c.restore(i, persister.ReadSnapshot())
+	}
+	c.mu.Unlock()
+
+	applyCh := make(chan ApplyMsg)
+	rf := c.makePeer(ends, i, persister, applyCh)
+	c.mu.Lock()
+	c.rafts[i] = rf
+	c.mu.Unlock()
+	go c.applier(i, rf, applyCh)
+
+	srv := labrpc.MakeServer()
+	srv.AddService(labrpc.MakeService(rf))
+	c.net.AddServer(fmt.Sprint(i), srv)
+}
+
+// Kills peer i, keeping what it persisted.
+func (c *cluster) crash(i int) {
+	c.disconnect(i)
+	c.net.DeleteServer(fmt.Sprint(i))
+
+	c.mu.Lock()
+	rf := c.rafts[i]
+	c.rafts[i] = nil
+	c.persisters[i] = c.persisters[i].Copy()
+	c.mu.Unlock()
+
+	if rf != nil {
+		rf.Kill()
+	}
+}
+
+func (c *cluster) connect(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected[i] = true
+	for j := 0; j < c.n; j++ {
+		if c.connected[j] {
+			c.net.Enable(c.endnames[i][j], true)
+			c.net.Enable(c.endnames[j][i], true)
+		}
+	}
+}
+
+func (c *cluster) disconnect(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connected[i] = false
+	for j := 0; j < c.n; j++ {
+		if c.endnames[i] != nil {
+			c.net.Enable(c.endnames[i][j], false)
+		}
+		if c.endnames[j] != nil {
+			c.net.Enable(c.endnames[j][i], false)
+		}
+	}
+}
+
+func (c *cluster) cleanup() {
+	for i := 0; i < c.n; i++ {
+		c.crash(i)
+	}
+}
+
+func (c *cluster) applier(i int, rf *Raft, applyCh chan ApplyMsg) {
+	lastSnapshot := 0
+	for msg := range applyCh {
+		if msg.SnapshotValid {
+			if rf.CondInstallSnapshot(msg.SnapshotTerm, msg.SnapshotIndex, msg.Snapshot) {
+				c.mu.Lock()
+				if c.rafts[i] == rf {
+					c.applied[i] = map[int]interface{}{}
+					c.restore(i, msg.Snapshot)
+					c.installed[i]++
+				}
+				c.mu.Unlock()
+				lastSnapshot = msg.SnapshotIndex
+			}
+			continue
+		}
+		if !msg.CommandValid {
+			continue
+		}
+
+		c.mu.Lock()
+		if c.rafts[i] != rf {
+			// Applied by an incarnation that has since been killed.
+			c.mu.Unlock()
+			continue
+		}
+		if old, ok := c.committed[msg.CommandIndex]; ok && old != msg.Command {
+			c.t.Errorf("peer %v applied %v at index %v, %v was applied there before",
+				i, msg.Command, msg.CommandIndex, old)
+		}
+		c.committed[msg.CommandIndex] = msg.Command
+		c.applied[i][msg.CommandIndex] = msg.Command
+		onApply := c.onApply
+		c.mu.Unlock()
+
+		if onApply != nil {
+			onApply(i, msg)
+		}
+
+		if c.snapshotInterval > 0 && msg.CommandIndex-lastSnapshot >= c.snapshotInterval {
+			c.mu.Lock()
+			snapshot := c.snapshot(i, msg.CommandIndex)
+			c.mu.Unlock()
+			rf.Snapshot(msg.CommandIndex, snapshot)
+			lastSnapshot = msg.CommandIndex
+		}
+	}
+}
+
+// Encodes the commands peer i applied through index.
+// Always call this while holding the cluster lock.
+func (c *cluster) snapshot(i int, index int) []byte {
+	commands := map[int]interface{}{}
+	for applied, command := range c.applied[i] {
+		if applied <= index {
+			commands[applied] = command
+		}
+	}
+	w := new(bytes.Buffer)
+	e := labgob.NewEncoder(w)
+	e.Encode(index)
+	e.Encode(commands)
+	return w.Bytes()
+}
+
+// Adds the commands in snapshot to what peer i applied, checking them
+// against what the others applied.
+// Always call this while holding the cluster lock.
+func (c *cluster) restore(i int, snapshot []byte) {
+	var index int
+	var commands map[int]interface{}
+	d := labgob.NewDecoder(bytes.NewBuffer(snapshot))
+	if d.Decode(&index) != nil || d.Decode(&commands) != nil {
+		c.t.Errorf("peer %v got a snapshot it can't decode", i)
+		return
+	}
+	for applied, command := range commands {
+		if old, ok := c.committed[applied]; ok && old != command {
+			c.t.Errorf("peer %v restored %v at index %v, %v was applied there before",
+				i, command, applied, old)
+		}
+		c.committed[applied] = command
+		c.applied[i][applied] = command
+	}
+}
+
+// Waits for a single leader among the connected peers and returns it.
+func (c *cluster) checkOneLeader() int {
+	for iters := 0; iters < 10; iters++ {
+		time.Sleep(time.Duration(450+iters*50) * time.Millisecond)
+
+		leaders := map[int][]int{}
+		for i := 0; i < c.n; i++ {
+			c.mu.Lock()
+			rf, connected := c.rafts[i], c.connected[i]
+			c.mu.Unlock()
+			if rf != nil && connected {
+				if term, isLeader := rf.GetState(); isLeader {
+					leaders[term] = append(leaders[term], i)
+				}
+			}
+		}
+
+		lastTerm := -1
+		for term, servers := range leaders {
+			if len(servers) > 1 {
+				c.t.Fatalf("term %v has %v leaders", term, len(servers))
+			}
+			lastTerm = max(lastTerm, term)
+		}
+		if len(leaders) != 0 {
+			return leaders[lastTerm][0]
+		}
+	}
+	c.t.Fatalf("expected one leader, got none")
+	return -1
+}
+
+// How many peers have applied index, and the command there.
+func (c *cluster) nCommitted(index int) (int, interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	count := 0
+	var command interface{}
+	for i := 0; i < c.n; i++ {
+		if applied, ok := c.applied[i][index]; ok {
+			count++
+			command = applied
+		}
+	}
+	return count, command
+}
+
+// Starts command on whichever connected peer is leader, and waits for
+// expected peers to apply it. Retries with other leaders if retry is set,
+// and fails the test if it can't get agreement within 10 seconds.
+func (c *cluster) one(command interface{}, expected int, retry bool) int {
+	start := time.Now()
+	server := 0
+	for time.Since(start) < 10*time.Second {
+		index := -1
+		for tried := 0; tried < c.n; tried++ {
+			server = (server + 1) % c.n
+			c.mu.Lock()
+			rf, connected := c.rafts[server], c.connected[server]
+			c.mu.Unlock()
+			if rf != nil && connected {
+				if i, _, isLeader := rf.Start(command); isLeader {
+					index = i
+					break
+				}
+			}
+		}
+
+		if index == -1 {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+		started := time.Now()
+		for time.Since(started) < 2*time.Second {
+			count, applied := c.nCommitted(index)
+			if count >= expected && applied == command {
+				return index
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		if !retry {
+			c.t.Fatalf("one(%v) failed to reach agreement", command)
+		}
+	}
+	c.t.Fatalf("one(%v) failed to reach agreement", command)
+	return -1
+}
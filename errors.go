@@ -0,0 +1,22 @@
+package raft
+
+import "errors"
+
+var (
+	// This server isn't the leader (or is handing leadership off).
+	ErrNotLeader = errors.New("raft: not the leader")
+	// The server named isn't one of the peers, or doesn't have the role
+	// the call needs.
+	ErrUnknownPeer = errors.New("raft: unknown peer")
+	// The target didn't catch up or win an election within an election timeout.
+	ErrTransferTimeout = errors.New("raft: leadership transfer timed out")
+	// A newer term started, or this server stopped being leader, before
+	// the request finished.
+	ErrLeadershipLost = errors.New("raft: leadership lost")
+	// The entry was overwritten by one from another leader.
+	ErrTruncated = errors.New("raft: entry truncated")
+	// A membership change is already under way, or not yet committed.
+	ErrConfigChangeInProgress = errors.New("raft: configuration change in progress")
+	// The learner doesn't yet have every committed entry.
+	ErrLearnerBehind = errors.New("raft: learner has not caught up")
+)
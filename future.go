@@ -0,0 +1,114 @@
+package raft
+
+import "context"
+
+// Propose is Start with a handle on what became of the entry, so the
+// service doesn't have to watch applyCh and compare terms itself. The
+// leader keeps the Future of each entry proposed in its term until the
+// entry is applied, it steps down, or the caller gives up on it.
+
+// A proposed entry, resolved once it has been applied or lost.
+type Future struct {
+	ctx   context.Context
+	index int
+	term  int
+	done  chan struct{}
+	// Set before done is closed
+	err error
+}
+
+// Appends command to the log like Start. Wait on the Future to learn
+// whether it was applied. It fails with ErrNotLeader if this server
+// isn't the leader, with ErrTruncated if the AppendEntries of a new
+// leader overwrites the entry (step 3), and with ErrLeadershipLost once
+// a new term begins or this server steps down otherwise, or is killed.
+// After ErrLeadershipLost, the entry may still be committed by the next
+// leader.
+func (rf *Raft) Propose(ctx context.Context, command interface{}) *Future {
+	future := &Future{ctx: ctx, done: make(chan struct{})}
+
+	// ----------------------------------------v Locked
+	rf.mu.Lock()
+	index, term, isLeader := rf.start(command)
+	future.index = index
+	future.term = term
+	if isLeader {
+		rf.futures[index] = future
+	} else {
+		future.resolve(ErrNotLeader)
+	}
+	rf.mu.Unlock()
+	// ----------------------------------------^ Locked
+
+	if isLeader && ctx.Done() != nil {
+		go rf.abandonFuture(future)
+	}
+	return future
+}
+
+// Drops the future once its context is done, so proposals nobody waits
+// for any more don't pile up in rf.futures.
+func (rf *Raft) abandonFuture(future *Future) {
+	select {
+	case <-future.done:
+	case <-future.ctx.Done():
+		rf.mu.Lock()
+		if rf.futures[future.index] == future {
+			delete(rf.futures, future.index)
+			future.resolve(future.ctx.Err())
+		}
+		rf.mu.Unlock()
+	}
+}
+
+// The index and term the entry was proposed at, as Start would return.
+func (f *Future) Index() int {
+	return f.index
+}
+
+func (f *Future) Term() int {
+	return f.term
+}
+
+// Blocks until the entry has been sent on applyCh, or installed with a
+// snapshot, and returns its index, or until it is lost. Returns
+// ctx.Err() if the context passed to Propose is done first.
+func (f *Future) Wait() (int, error) {
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return -1, f.err
+		}
+		return f.index, nil
+	case <-f.ctx.Done():
+		return -1, f.ctx.Err()
+	}
+}
+
+// Only called once, by whoever took the future out of rf.futures.
+func (f *Future) resolve(err error) {
+	f.err = err
+	close(f.done)
+}
+
+// Fails the futures of every entry from index on.
+// Always call this while holding the raft lock.
+func (rf *Raft) failFutures(index int, err error) {
+	for i, future := range rf.futures {
+		if i >= index {
+			delete(rf.futures, i)
+			future.resolve(err)
+		}
+	}
+}
+
+// Resolves the futures of every entry up to and including index.
+// Always call this while holding the raft lock.
+func (rf *Raft) resolveFutures(index int, err error) {
+	for i, future := range rf.futures {
+		if i <= index {
+			delete(rf.futures, i)
+			future.resolve(err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package raft
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPropose(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	leader := c.checkOneLeader()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	future := c.rafts[leader].Propose(ctx, 100)
+	index, err := future.Wait()
+	if err != nil || index != future.Index() {
+		t.Fatalf("Wait returned %v, %v, want %v", index, err, future.Index())
+	}
+	// The applier may not have recorded it yet.
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if count, _ := c.nCommitted(index); count > 0 {
+			break
+		}
+	}
+	if _, applied := c.nCommitted(index); applied != 100 {
+		t.Fatalf("index %v holds %v, want 100", index, applied)
+	}
+
+	if _, err := c.rafts[(leader+1)%3].Propose(ctx, 101).Wait(); err != ErrNotLeader {
+		t.Fatalf("follower's Propose returned %v, want %v", err, ErrNotLeader)
+	}
+}
+
+// A new leader's AppendEntries overwrites the last two of three
+// entries a cut off leader proposed. Those fail with ErrTruncated, the
+// one left with ErrLeadershipLost, since the term moved on.
+func TestProposeTruncated(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	c.disconnect(leader)
+	rf := c.rafts[leader]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	futures := []*Future{}
+	for i := 0; i < 3; i++ {
+		future := rf.Propose(ctx, 10+i)
+		if future.Index() == -1 {
+			t.Fatalf("leader stepped down before proposing")
+		}
+		futures = append(futures, future)
+	}
+
+	term := futures[0].Term()
+	args := AppendEntriesArgs{
+		Term:         term + 1,
+		LeaderId:     (leader + 1) % 3,
+		PrevLogIndex: futures[0].Index(),
+		PrevLogTerm:  term,
+		Entries:      []LogEntry{{Term: term + 1, Entry: 20}},
+	}
+	reply := AppendEntriesReply{}
+	rf.AppendEntries(&args, &reply)
+	if !reply.Success {
+		t.Fatalf("AppendEntries from the new leader failed: %+v", reply)
+	}
+
+	if _, err := futures[0].Wait(); err != ErrLeadershipLost {
+		t.Fatalf("future at index %v returned %v, want %v", futures[0].Index(), err, ErrLeadershipLost)
+	}
+	for _, future := range futures[1:] {
+		if _, err := future.Wait(); err != ErrTruncated {
+			t.Fatalf("future at index %v returned %v, want %v", future.Index(), err, ErrTruncated)
+		}
+	}
+}
+
+// A leader that steps down fails its futures right away, whether or not
+// the next leader goes on to commit the entries.
+func TestProposeStepDown(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	leader := c.checkOneLeader()
+	rf := c.rafts[leader]
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	future := rf.Propose(ctx, 100)
+	if future.Index() == -1 {
+		t.Fatalf("leader stepped down before proposing")
+	}
+
+	args := RequestVoteArgs{Term: future.Term() + 1, CandidateId: (leader + 1) % 3}
+	rf.RequestVote(&args, &RequestVoteReply{})
+	if _, err := future.Wait(); err != ErrLeadershipLost {
+		t.Fatalf("future returned %v after a new term began, want %v", err, ErrLeadershipLost)
+	}
+	if _, isLeader := rf.GetState(); isLeader {
+		t.Fatalf("still leader after seeing a higher term")
+	}
+	c.one(101, 3, true)
+}
+
+// A future whose context is done leaves rf.futures, even though its
+// entry is still waiting to commit.
+func TestProposeAbandoned(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	leader := c.checkOneLeader()
+	c.disconnect(leader)
+	rf := c.rafts[leader]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	future := rf.Propose(ctx, 100)
+	if future.Index() == -1 {
+		t.Fatalf("leader stepped down before proposing")
+	}
+	cancel()
+	if _, err := future.Wait(); err != context.Canceled {
+		t.Fatalf("Wait returned %v after cancel, want %v", err, context.Canceled)
+	}
+
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		rf.mu.Lock()
+		_, kept := rf.futures[future.Index()]
+		rf.mu.Unlock()
+		if !kept {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("future still in rf.futures a second after its context was cancelled")
+		}
+	}
+}
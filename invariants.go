@@ -0,0 +1,220 @@
+package raft
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// InvariantChecker is a debug mode for test clusters. Every peer built
+// with the same checker in its Config reports its state whenever its
+// log, commitIndex or leadership changes, and the checker asserts the
+// safety properties of Figure 3 across all of them:
+//
+//   - Election Safety: at most one leader per term.
+//   - Log Matching: logs holding an entry with the same index and term
+//     are identical up to it.
+//   - Leader Completeness: a leader holds every entry committed so far.
+//   - State Machine Safety: no two peers apply different commands at the
+//     same index.
+//
+// Reports are checked against the latest report of every other peer,
+// not their live state, so no peer's lock is taken while another's is
+// held. Checking costs time proportional to the log on every report.
+
+type InvariantChecker struct {
+	mu sync.Mutex
+	// Called with the first violation, panics if nil
+	onViolation func(err error)
+	// Set after a violation, nothing is checked from then on
+	failed bool
+
+	// Latest report from each peer
+	states map[int]peerState
+	// Leader seen in each term
+	leaders map[int]int
+	// Each committed entry, and how far each peer has committed
+	committed   map[int]committedEntry
+	commitIndex map[int]int
+	// Command applied at each index, by whichever peer applied it first
+	applied map[int]interface{}
+}
+
+type committedEntry struct {
+	term int
+	// Lowest term of a peer that reported it committed. It was committed
+	// in that term or earlier, so every leader of a later term has it.
+	seenIn int
+}
+
+// A copy of a peer's state, taken under its lock.
+type peerState struct {
+	term        int
+	votedFor    int
+	state       StateType
+	commitIndex int
+	lastApplied int
+	// LastIncludedIndex, and the log from there on
+	first   int
+	entries []LogEntry
+}
+
+// Makes a checker to share between the peers of one cluster.
+// onViolation gets the first violation, with a dump of every peer's
+// last reported state. If nil, the checker panics instead. It is called
+// with the reporting peer's lock held, so it must not call into Raft.
+func NewInvariantChecker(onViolation func(err error)) *InvariantChecker {
+	return &InvariantChecker{
+		onViolation: onViolation,
+		states:      map[int]peerState{},
+		leaders:     map[int]int{},
+		committed:   map[int]committedEntry{},
+		commitIndex: map[int]int{},
+		applied:     map[int]interface{}{},
+	}
+}
+
+// Always call this while holding the raft lock.
+func (rf *Raft) copyState() peerState {
+	return peerState{
+		term:        rf.currentTerm,
+		votedFor:    rf.votedFor,
+		state:       rf.state,
+		commitIndex: rf.commitIndex,
+		lastApplied: rf.lastApplied,
+		first:       rf.log.LastIncludedIndex,
+		entries:     append([]LogEntry{}, rf.log.Entries...),
+	}
+}
+
+// Reports this peer's state to the checker, if there is one.
+// Always call this while holding the raft lock.
+func (rf *Raft) checkInvariants() {
+	if rf.conf.Checker != nil {
+		rf.conf.Checker.report(rf.me, rf.copyState())
+	}
+}
+
+func (c *InvariantChecker) report(me int, state peerState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failed {
+		return
+	}
+	c.states[me] = state
+
+	if state.state == LeaderState {
+		if other, ok := c.leaders[state.term]; ok && other != me {
+			c.fail("election safety: %v and %v both led term %v", other, me, state.term)
+			return
+		}
+		c.leaders[state.term] = me
+	}
+
+	for other, otherState := range c.states {
+		if other == me {
+			continue
+		}
+		if index, ok := logsMatch(state, otherState); !ok {
+			c.fail("log matching: %v and %v share an entry but differ at index %v", me, other, index)
+			return
+		}
+	}
+
+	// Entries at or before the snapshot are trusted to have been checked
+	// when they were still in the log.
+	for index := max(c.commitIndex[me], state.first) + 1; index <= state.commitIndex; index++ {
+		term := state.entries[index-state.first].Term
+		entry, ok := c.committed[index]
+		if ok && entry.term != term {
+			c.fail("%v committed an entry from term %v at index %v, another peer one from term %v",
+				me, term, index, entry.term)
+			return
+		}
+		if !ok || state.term < entry.seenIn {
+			c.committed[index] = committedEntry{term: term, seenIn: state.term}
+		}
+	}
+	c.commitIndex[me] = max(c.commitIndex[me], state.commitIndex)
+
+	if state.state == LeaderState {
+		for index, entry := range c.committed {
+			if index <= state.first || state.term <= entry.seenIn {
+				continue
+			}
+			if index-state.first >= len(state.entries) || state.entries[index-state.first].Term != entry.term {
+				c.fail("leader completeness: %v leads term %v without index %v, committed by term %v",
+					me, state.term, index, entry.seenIn)
+				return
+			}
+		}
+	}
+}
+
+// Records that me applied command at index.
+func (c *InvariantChecker) apply(me int, index int, command interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failed {
+		return
+	}
+	if other, ok := c.applied[index]; ok && !reflect.DeepEqual(other, command) {
+		c.fail("state machine safety: %v applied %v at index %v, another peer applied %v",
+			me, command, index, other)
+		return
+	}
+	c.applied[index] = command
+}
+
+// Always call this while holding the checker lock.
+func (c *InvariantChecker) fail(format string, args ...interface{}) {
+	c.failed = true
+
+	servers := []int{}
+	for server := range c.states {
+		servers = append(servers, server)
+	}
+	sort.Ints(servers)
+
+	var dump strings.Builder
+	for _, server := range servers {
+		state := c.states[server]
+		fmt.Fprintf(&dump, "\n  peer %v: term=%v votedFor=%v state=%v commit=%v applied=%v snapshot=%v log=%v",
+			server, state.term, state.votedFor, state.state, state.commitIndex, state.lastApplied,
+			state.first, state.entries)
+	}
+	err := fmt.Errorf("raft: invariant violated: %v%v", fmt.Sprintf(format, args...), dump.String())
+
+	if c.onViolation == nil {
+		panic(err)
+	}
+	c.onViolation(err)
+}
+
+// Whether the logs are identical up to the last index where their terms
+// agree, and if not the index where they first differ going down.
+func logsMatch(a, b peerState) (int, bool) {
+	low := max(a.first, b.first)
+	high := min(a.first+len(a.entries)-1, b.first+len(b.entries)-1)
+	agreed := false
+	for index := high; index >= low; index-- {
+		x := a.entries[index-a.first]
+		y := b.entries[index-b.first]
+		if !agreed && x.Term != y.Term {
+			continue
+		}
+		agreed = true
+		if x.Term != y.Term {
+			return index, false
+		}
+		// At low, one of them only has the term kept with its snapshot.
+		if index > low && !reflect.DeepEqual(x.Entry, y.Entry) {
+			return index, false
+		}
+	}
+	return 0, true
+}
@@ -0,0 +1,98 @@
+package kvraft
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+
+	"6.824/labrpc"
+)
+
+// How long a Clerk waits after every server has turned it away, to give
+// the cluster time to elect a leader.
+const retryInterval = 50 * time.Millisecond
+
+type Clerk struct {
+	servers []*labrpc.ClientEnd
+	// Server that last answered as leader, tried first
+	leader int
+	// This clerk's session, and the number of its latest Put or Append
+	clientId int64
+	seq      int64
+}
+
+func nrand() int64 {
+	max := big.NewInt(int64(1) << 62)
+	bigx, _ := rand.Int(rand.Reader, max)
+	x := bigx.Int64()
+	return x
+}
+
+func MakeClerk(servers []*labrpc.ClientEnd) *Clerk {
+	ck := new(Clerk)
+	ck.servers = servers
+	ck.clientId = nrand()
+	return ck
+}
+
+// fetch the current value for a key.
+// returns "" if the key does not exist.
+// keeps trying forever in the face of all other errors.
+func (ck *Clerk) Get(key string) string {
+	args := GetArgs{Key: key}
+	for tried := 0; ; tried++ {
+		reply := GetReply{}
+		ok := ck.servers[ck.leader].Call("KVServer.Get", &args, &reply)
+		if ok && reply.Err == OK {
+			return reply.Value
+		}
+		if ok && reply.Err == ErrNoKey {
+			return ""
+		}
+		ck.nextServer(tried)
+	}
+}
+
+// shared by Put and Append.
+// keeps trying forever until the op is applied. Every retry carries the
+// same sequence number, so the op is applied once however many servers
+// started it.
+func (ck *Clerk) PutAppend(key string, value string, op string) {
+	ck.seq++
+	args := PutAppendArgs{Key: key, Value: value, Op: op, ClientId: ck.clientId, Seq: ck.seq}
+	for tried := 0; ; tried++ {
+		reply := PutAppendReply{}
+		ok := ck.servers[ck.leader].Call("KVServer.PutAppend", &args, &reply)
+		if ok && reply.Err == OK {
+			return
+		}
+		if ok && reply.Err == ErrSessionExpired {
+			// The servers dropped the session after it sat idle for longer
+			// than sessionTimeout of log time. Carry on in a new one. Should
+			// an earlier try of this op have been applied just before, it
+			// will be applied twice.
+			ck.clientId = nrand()
+			ck.seq = 1
+			args.ClientId, args.Seq = ck.clientId, ck.seq
+			continue
+		}
+		ck.nextServer(tried)
+	}
+}
+
+func (ck *Clerk) Put(key string, value string) {
+	ck.PutAppend(key, value, "Put")
+}
+
+func (ck *Clerk) Append(key string, value string) {
+	ck.PutAppend(key, value, "Append")
+}
+
+// Moves on to the next server after the tried'th failed attempt, backing
+// off once a whole round has failed.
+func (ck *Clerk) nextServer(tried int) {
+	ck.leader = (ck.leader + 1) % len(ck.servers)
+	if (tried+1)%len(ck.servers) == 0 {
+		time.Sleep(retryInterval)
+	}
+}
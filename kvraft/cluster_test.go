@@ -0,0 +1,206 @@
+package kvraft
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+	"6.824/raft"
+	"6.824/raft/linearizability"
+)
+
+// A cluster of KVServers on a labrpc network for tests. Servers can be
+// cut off from the others, crashed and restarted from what they
+// persisted, while clerks keep running against them.
+type cluster struct {
+	t   *testing.T
+	mu  sync.Mutex
+	n   int
+	net *labrpc.Network
+
+	kvs          []*KVServer
+	persisters   []*raft.Persister
+	maxraftstate int
+	// endnames[i][j] is the end server i uses to reach server j
+	endnames [][]string
+	// Ends of every clerk made, by server
+	clerkEnds [][]string
+}
+
+func makeCluster(t *testing.T, n int, maxraftstate int, unreliable bool) *cluster {
+	c := &cluster{
+		t:            t,
+		n:            n,
+		net:          labrpc.MakeNetwork(),
+		kvs:          make([]*KVServer, n),
+		persisters:   make([]*raft.Persister, n),
+		maxraftstate: maxraftstate,
+		endnames:     make([][]string, n),
+		clerkEnds:    make([][]string, n),
+	}
+	c.net.Reliable(!unreliable)
+	for i := 0; i < n; i++ {
+		c.persisters[i] = raft.MakePersister()
+		c.start(i)
+	}
+	return c
+}
+
+// Starts server i from whatever it persisted, connected to every other.
+func (c *cluster) start(i int) {
+	c.endnames[i] = make([]string, c.n)
+	ends := make([]*labrpc.ClientEnd, c.n)
+	for j := 0; j < c.n; j++ {
+		c.endnames[i][j] = fmt.Sprintf("%v-%v-%v", i, j, time.Now().UnixNano())
+		ends[j] = c.net.MakeEnd(c.endnames[i][j])
+		c.net.Connect(c.endnames[i][j], fmt.Sprint(j))
+		c.net.Enable(c.endnames[i][j], true)
+	}
+
+	c.mu.Lock()
+	// A copy, so a killed incarnation still writing can't touch it.
+	c.persisters[i] = c.persisters[i].Copy()
+	persister := c.persisters[i]
+	c.mu.Unlock()
+
+	kv := StartKVServer(ends, i, persister, c.maxraftstate)
+	c.mu.Lock()
+	c.kvs[i] = kv
+	c.mu.Unlock()
+
+	srv := labrpc.MakeServer()
+	srv.AddService(labrpc.MakeService(kv))
+	srv.AddService(labrpc.MakeService(kv.rf))
+	c.net.AddServer(fmt.Sprint(i), srv)
+	c.connect(i, true)
+}
+
+// Kills server i, keeping what it persisted.
+func (c *cluster) crash(i int) {
+	c.connect(i, false)
+	c.net.DeleteServer(fmt.Sprint(i))
+
+	c.mu.Lock()
+	kv := c.kvs[i]
+	c.kvs[i] = nil
+	c.persisters[i] = c.persisters[i].Copy()
+	c.mu.Unlock()
+
+	if kv != nil {
+		kv.Kill()
+	}
+}
+
+// Cuts server i off from the other servers and from clerks, or connects
+// it again.
+func (c *cluster) connect(i int, on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for j := 0; j < c.n; j++ {
+		if c.endnames[i] != nil {
+			c.net.Enable(c.endnames[i][j], on)
+		}
+		if c.endnames[j] != nil {
+			c.net.Enable(c.endnames[j][i], on)
+		}
+	}
+	for _, name := range c.clerkEnds[i] {
+		c.net.Enable(name, on)
+	}
+}
+
+func (c *cluster) cleanup() {
+	for i := 0; i < c.n; i++ {
+		c.crash(i)
+	}
+}
+
+func (c *cluster) makeClerk() *Clerk {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ends := make([]*labrpc.ClientEnd, c.n)
+	for j := 0; j < c.n; j++ {
+		name := fmt.Sprintf("clerk-%v-%v", j, rand.Int63())
+		ends[j] = c.net.MakeEnd(name)
+		c.net.Connect(name, fmt.Sprint(j))
+		c.net.Enable(name, true)
+		c.clerkEnds[j] = append(c.clerkEnds[j], name)
+	}
+	return MakeClerk(ends)
+}
+
+// The server that thinks it is leader, waiting up to a few seconds for
+// one to be elected.
+func (c *cluster) leader() int {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		for i := 0; i < c.n; i++ {
+			c.mu.Lock()
+			kv := c.kvs[i]
+			c.mu.Unlock()
+			if kv == nil {
+				continue
+			}
+			if _, isLeader := kv.rf.GetState(); isLeader {
+				return i
+			}
+		}
+	}
+	c.t.Fatalf("no leader")
+	return -1
+}
+
+// Runs nclients clerks doing random Gets, Puts and Appends on a few keys
+// for d, while chaos (if set) runs until it is told to stop, then checks
+// the history is linearizable.
+func (c *cluster) runClients(nclients int, d time.Duration, chaos func(stop *int32)) {
+	recorder := linearizability.NewRecorder()
+	var stop int32
+	var wg sync.WaitGroup
+	for client := 0; client < nclients; client++ {
+		ck := c.makeClerk()
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(client)))
+			for i := 0; atomic.LoadInt32(&stop) == 0; i++ {
+				key := fmt.Sprint(r.Intn(3))
+				switch r.Intn(3) {
+				case 0:
+					op := recorder.Invoke(client, linearizability.KvInput{Op: linearizability.KvGet, Key: key})
+					op.Return(linearizability.KvOutput{Value: ck.Get(key)})
+				case 1:
+					value := fmt.Sprintf("a%v.%v ", client, i)
+					op := recorder.Invoke(client, linearizability.KvInput{Op: linearizability.KvAppend, Key: key, Value: value})
+					ck.Append(key, value)
+					op.Return(linearizability.KvOutput{})
+				default:
+					value := fmt.Sprintf("p%v.%v ", client, i)
+					op := recorder.Invoke(client, linearizability.KvInput{Op: linearizability.KvPut, Key: key, Value: value})
+					ck.Put(key, value)
+					op.Return(linearizability.KvOutput{})
+				}
+			}
+		}(client)
+	}
+
+	done := make(chan bool)
+	go func() {
+		if chaos != nil {
+			chaos(&stop)
+		}
+		done <- true
+	}()
+	time.Sleep(d)
+	atomic.StoreInt32(&stop, 1)
+	<-done
+	wg.Wait()
+
+	result, _ := linearizability.CheckOperationsVerbose(linearizability.KvModel(), recorder.History(), 30*time.Second)
+	if result == linearizability.Illegal {
+		c.t.Fatalf("history is not linearizable")
+	}
+}
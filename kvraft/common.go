@@ -0,0 +1,38 @@
+package kvraft
+
+const (
+	OK             = "OK"
+	ErrNoKey       = "ErrNoKey"
+	ErrWrongLeader = "ErrWrongLeader"
+	// The server started the op but didn't see it applied in time, e.g.
+	// because it was cut off from the rest of the cluster.
+	ErrTimeout = "ErrTimeout"
+	// The client's session was dropped after going idle, so the servers
+	// can no longer tell whether its request was already applied.
+	ErrSessionExpired = "ErrSessionExpired"
+)
+
+type Err string
+
+// Put or Append
+type PutAppendArgs struct {
+	Key   string
+	Value string
+	Op    string // "Put" or "Append"
+	// Identifies the request, so a retry of it is only applied once
+	ClientId int64
+	Seq      int64
+}
+
+type PutAppendReply struct {
+	Err Err
+}
+
+type GetArgs struct {
+	Key string
+}
+
+type GetReply struct {
+	Err   Err
+	Value string
+}
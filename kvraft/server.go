@@ -0,0 +1,315 @@
+package kvraft
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"6.824/labgob"
+	"6.824/labrpc"
+	"6.824/raft"
+)
+
+// How long a handler waits for its op to be applied before telling the
+// Clerk to try another server.
+const applyTimeout = 500 * time.Millisecond
+
+// How long a client session is kept after its last Put or Append, in log
+// time. Sessions expire by the timestamps leaders put on ops rather than
+// by each server's own clock, so every server drops them at the same
+// point in the log.
+const sessionTimeout = 10 * time.Minute
+
+// A client request, as it goes through the Raft log.
+type Op struct {
+	Type  string // "Get", "Put" or "Append"
+	Key   string
+	Value string
+	// Session and sequence number of a Put or Append
+	ClientId int64
+	Seq      int64
+	// When the leader started the op, in UnixNano
+	Time int64
+}
+
+// The latest Put or Append applied for a client.
+type session struct {
+	Seq int64
+	// Log time it was applied at
+	LastActive int64
+}
+
+// What applying the entry at an index gave, for the handler waiting on it.
+type applyResult struct {
+	// Term of the entry applied, -1 if it wasn't a client op or was
+	// skipped over by a snapshot
+	term  int
+	err   Err
+	value string
+}
+
+type KVServer struct {
+	mu      sync.Mutex
+	me      int
+	rf      *raft.Raft
+	applyCh chan raft.ApplyMsg
+	dead    int32 // set by Kill()
+
+	maxraftstate int // snapshot if log grows this big
+	persister    *raft.Persister
+
+	data map[string]string
+	// Index of the last entry applied to data
+	lastApplied int
+	// Handlers waiting for the entry at an index to be applied
+	waiting map[int]chan applyResult
+
+	// Clients' sessions, so retried ops aren't applied twice. Saved in
+	// snapshots along with data.
+	sessions map[int64]session
+	// Latest op time applied. Never goes back, even if the clocks of
+	// successive leaders do.
+	logTime int64
+	// Log time expired sessions were last cleared out at
+	lastSweep int64
+}
+
+func (kv *KVServer) Get(args *GetArgs, reply *GetReply) {
+	reply.Err, reply.Value = kv.start(Op{Type: "Get", Key: args.Key})
+}
+
+func (kv *KVServer) PutAppend(args *PutAppendArgs, reply *PutAppendReply) {
+	reply.Err, _ = kv.start(Op{Type: args.Op, Key: args.Key, Value: args.Value, ClientId: args.ClientId, Seq: args.Seq})
+}
+
+// Starts op in Raft and waits for it to be applied. Fails with
+// ErrWrongLeader if this server isn't leading, or if a different entry
+// was committed at the index op was started at.
+func (kv *KVServer) start(op Op) (Err, string) {
+	op.Time = time.Now().UnixNano()
+
+	// ----------------------------------------v Locked
+	// Held across Start so the entry can't be applied before the
+	// handler is waiting on it.
+	kv.mu.Lock()
+	index, term, isLeader := kv.rf.Start(op)
+	if !isLeader {
+		kv.mu.Unlock()
+		return ErrWrongLeader, ""
+	}
+	if old, ok := kv.waiting[index]; ok {
+		// Started at the same index in an earlier term, so it was overwritten.
+		old <- applyResult{term: -1}
+	}
+	ch := make(chan applyResult, 1)
+	kv.waiting[index] = ch
+	kv.mu.Unlock()
+	// ----------------------------------------^ Locked
+
+	select {
+	case result := <-ch:
+		if result.term != term {
+			return ErrWrongLeader, ""
+		}
+		return result.err, result.value
+	case <-time.After(applyTimeout):
+		kv.mu.Lock()
+		if kv.waiting[index] == ch {
+			delete(kv.waiting, index)
+		}
+		kv.mu.Unlock()
+		return ErrTimeout, ""
+	}
+}
+
+// Applies committed entries and snapshots from Raft to data, waking the
+// handlers waiting on them.
+func (kv *KVServer) applier() {
+	for !kv.killed() {
+		msg := <-kv.applyCh
+
+		kv.mu.Lock()
+		switch {
+		case msg.SnapshotValid:
+			if kv.rf.CondInstallSnapshot(msg.SnapshotTerm, msg.SnapshotIndex, msg.Snapshot) {
+				kv.readSnapshot(msg.Snapshot)
+				// Whatever the waiting handlers started may or may not be in it.
+				for index, ch := range kv.waiting {
+					if index <= kv.lastApplied {
+						ch <- applyResult{term: -1}
+						delete(kv.waiting, index)
+					}
+				}
+			}
+		case msg.CommandValid:
+			if msg.CommandIndex > kv.lastApplied {
+				result := kv.apply(msg.Command.(Op))
+				result.term = msg.CommandTerm
+				kv.applied(msg.CommandIndex, result)
+			}
+		case msg.ConfigValid:
+			if msg.ConfigIndex > kv.lastApplied {
+				kv.applied(msg.ConfigIndex, applyResult{term: -1})
+			}
+		case msg.NoOpValid:
+			if msg.NoOpIndex > kv.lastApplied {
+				kv.applied(msg.NoOpIndex, applyResult{term: -1})
+			}
+		}
+		kv.mu.Unlock()
+	}
+}
+
+// Always call this while holding the kv lock.
+func (kv *KVServer) apply(op Op) applyResult {
+	if op.Time > kv.logTime {
+		kv.logTime = op.Time
+	}
+	kv.sweepSessions()
+
+	if op.Type == "Put" || op.Type == "Append" {
+		s, ok := kv.session(op.ClientId)
+		if !ok && op.Seq > 1 {
+			return applyResult{err: ErrSessionExpired}
+		}
+		if ok && op.Seq <= s.Seq {
+			// A retry of an op already applied
+			return applyResult{err: OK}
+		}
+		kv.sessions[op.ClientId] = session{Seq: op.Seq, LastActive: kv.logTime}
+	}
+
+	switch op.Type {
+	case "Put":
+		kv.data[op.Key] = op.Value
+	case "Append":
+		kv.data[op.Key] += op.Value
+	case "Get":
+		value, ok := kv.data[op.Key]
+		if !ok {
+			return applyResult{err: ErrNoKey}
+		}
+		return applyResult{err: OK, value: value}
+	}
+	return applyResult{err: OK}
+}
+
+// The client's session, unless it has expired.
+// Always call this while holding the kv lock.
+func (kv *KVServer) session(clientId int64) (session, bool) {
+	s, ok := kv.sessions[clientId]
+	if ok && kv.logTime-s.LastActive > int64(sessionTimeout) {
+		delete(kv.sessions, clientId)
+		return session{}, false
+	}
+	return s, ok
+}
+
+// Drops expired sessions, once every sessionTimeout of log time. Expiry
+// is decided by session() when a client next shows up, so when this runs
+// doesn't change what gets applied; it only frees the memory.
+// Always call this while holding the kv lock.
+func (kv *KVServer) sweepSessions() {
+	if kv.logTime-kv.lastSweep <= int64(sessionTimeout) {
+		return
+	}
+	for clientId := range kv.sessions {
+		kv.session(clientId)
+	}
+	kv.lastSweep = kv.logTime
+}
+
+// Records that the entry at index was applied, hands result to the
+// handler waiting on it, and snapshots if the log has grown too big.
+// Always call this while holding the kv lock.
+func (kv *KVServer) applied(index int, result applyResult) {
+	kv.lastApplied = index
+	if ch, ok := kv.waiting[index]; ok {
+		ch <- result
+		delete(kv.waiting, index)
+	}
+	if kv.maxraftstate != -1 && kv.persister.RaftStateSize() >= kv.maxraftstate {
+		kv.rf.Snapshot(index, kv.encodeSnapshot())
+	}
+}
+
+// Always call this while holding the kv lock.
+func (kv *KVServer) encodeSnapshot() []byte {
+	w := new(bytes.Buffer)
+	e := labgob.NewEncoder(w)
+	e.Encode(kv.lastApplied)
+	e.Encode(kv.data)
+	e.Encode(kv.sessions)
+	e.Encode(kv.logTime)
+	return w.Bytes()
+}
+
+// Always call this while holding the kv lock.
+func (kv *KVServer) readSnapshot(snapshot []byte) {
+	if len(snapshot) == 0 {
+		return
+	}
+	r := bytes.NewBuffer(snapshot)
+	d := labgob.NewDecoder(r)
+	var lastApplied int
+	var data map[string]string
+	var sessions map[int64]session
+	var logTime int64
+	if d.Decode(&lastApplied) != nil || d.Decode(&data) != nil ||
+		d.Decode(&sessions) != nil || d.Decode(&logTime) != nil {
+		panic("kvraft: error decoding snapshot")
+	}
+	kv.lastApplied = lastApplied
+	kv.data = data
+	kv.sessions = sessions
+	kv.logTime = logTime
+}
+
+// the tester calls Kill() when a KVServer instance won't
+// be needed again. for your convenience, we supply
+// code to set rf.dead (without needing a lock),
+// and a killed() method to test rf.dead in
+// long-running loops.
+func (kv *KVServer) Kill() {
+	atomic.StoreInt32(&kv.dead, 1)
+	kv.rf.Kill()
+}
+
+func (kv *KVServer) killed() bool {
+	z := atomic.LoadInt32(&kv.dead)
+	return z == 1
+}
+
+// servers[] contains the ports of the set of
+// servers that will cooperate via Raft to
+// form the fault-tolerant key/value service.
+// me is the index of the current server in servers[].
+// the k/v server should store snapshots through the underlying Raft
+// implementation, which should call persister.SaveStateAndSnapshot() to
+// atomically save the Raft state along with the snapshot.
+// the k/v server should snapshot when Raft's saved state exceeds
+// maxraftstate bytes. if maxraftstate is -1, you don't need to snapshot.
+// StartKVServer() must return quickly, so it should start goroutines
+// for any long-running work.
+func StartKVServer(servers []*labrpc.ClientEnd, me int, persister *raft.Persister, maxraftstate int) *KVServer {
+	// call labgob.Register on structures you want
+	// Go's RPC library to marshall/unmarshall.
+	labgob.Register(Op{})
+
+	kv := new(KVServer)
+	kv.me = me
+	kv.maxraftstate = maxraftstate
+	kv.persister = persister
+	kv.data = map[string]string{}
+	kv.waiting = map[int]chan applyResult{}
+	kv.sessions = map[int64]session{}
+	kv.readSnapshot(persister.ReadSnapshot())
+
+	kv.applyCh = make(chan raft.ApplyMsg)
+	kv.rf = raft.Make(servers, me, persister, kv.applyCh)
+
+	go kv.applier()
+
+	return kv
+}
@@ -0,0 +1,147 @@
+package kvraft
+
+import (
+	"testing"
+	"time"
+)
+
+// A server with no Raft underneath, for applying ops by hand.
+func makeStateMachine() *KVServer {
+	return &KVServer{data: map[string]string{}, sessions: map[int64]session{}}
+}
+
+func appendOp(clientId int64, seq int64, value string, at int64) Op {
+	return Op{Type: "Append", Key: "k", Value: value, ClientId: clientId, Seq: seq, Time: at}
+}
+
+func TestSessionDedup(t *testing.T) {
+	kv := makeStateMachine()
+	if err := kv.apply(appendOp(1, 1, "a", 1)).err; err != OK {
+		t.Fatalf("first Append returned %v", err)
+	}
+	// The same request again, e.g. started by a second leader.
+	if err := kv.apply(appendOp(1, 1, "a", 2)).err; err != OK {
+		t.Fatalf("retried Append returned %v, want %v", err, OK)
+	}
+	kv.apply(appendOp(1, 2, "b", 3))
+	// An older request arriving late is skipped too.
+	kv.apply(appendOp(1, 1, "a", 4))
+	// Another client's sequence numbers are its own.
+	kv.apply(appendOp(2, 1, "c", 5))
+	if value := kv.data["k"]; value != "abc" {
+		t.Fatalf("k is %q, want %q", value, "abc")
+	}
+}
+
+// The dedup table and log time travel with the snapshot, so a server
+// restored from one still skips retries.
+func TestSessionSnapshot(t *testing.T) {
+	kv := makeStateMachine()
+	kv.apply(appendOp(1, 1, "a", 10))
+	kv.apply(appendOp(2, 1, "b", 20))
+	kv.lastApplied = 2
+
+	restored := makeStateMachine()
+	restored.readSnapshot(kv.encodeSnapshot())
+	if restored.lastApplied != 2 || restored.logTime != 20 {
+		t.Fatalf("restored lastApplied %v and log time %v, want 2 and 20", restored.lastApplied, restored.logTime)
+	}
+	restored.apply(appendOp(1, 1, "a", 30))
+	restored.apply(appendOp(2, 1, "b", 31))
+	restored.apply(appendOp(1, 2, "c", 32))
+	if value := restored.data["k"]; value != "abc" {
+		t.Fatalf("k is %q after restoring, want %q", value, "abc")
+	}
+}
+
+// Sessions expire by the times leaders put on ops, whatever each
+// server's own clock says. Here ops are stamped early in 1970, long
+// before now, and still nothing expires until sessionTimeout of log time
+// has passed.
+func TestSessionExpiry(t *testing.T) {
+	kv := makeStateMachine()
+	start := int64(time.Second)
+	kv.apply(appendOp(1, 1, "a", start))
+
+	// A leader whose clock is behind doesn't move log time back.
+	kv.apply(appendOp(2, 1, "b", start-int64(time.Hour)))
+	if kv.logTime != start {
+		t.Fatalf("log time went back to %v", kv.logTime)
+	}
+
+	kv.apply(appendOp(2, 2, "c", start+int64(sessionTimeout)))
+	if err := kv.apply(appendOp(1, 2, "d", start+int64(sessionTimeout))).err; err != OK {
+		t.Fatalf("Append right at the timeout returned %v, want %v", err, OK)
+	}
+	if value := kv.data["k"]; value != "abcd" {
+		t.Fatalf("k is %q, want %q", value, "abcd")
+	}
+
+	later := start + 2*int64(sessionTimeout) + 1
+	if err := kv.apply(appendOp(1, 3, "e", later)).err; err != ErrSessionExpired {
+		t.Fatalf("Append after the session expired returned %v, want %v", err, ErrSessionExpired)
+	}
+	// Expired sessions are swept out, not just ignored.
+	if len(kv.sessions) != 0 {
+		t.Fatalf("%v sessions left after they all expired", len(kv.sessions))
+	}
+	// A new session starts at 1.
+	if err := kv.apply(appendOp(3, 1, "f", later)).err; err != OK {
+		t.Fatalf("first Append of a new session returned %v, want %v", err, OK)
+	}
+}
+
+// What the Clerk does on ErrSessionExpired: it retries in a new session.
+// If an earlier try of the op was applied before the old session
+// expired, the op is applied twice. This is the one case sessions don't
+// cover.
+func TestSessionExpiredRetryAppliesTwice(t *testing.T) {
+	kv := makeStateMachine()
+	start := int64(time.Second)
+	kv.apply(appendOp(1, 1, "a", start))
+	kv.apply(appendOp(1, 2, "b", start))
+
+	// The reply was lost, and the retry only arrives once the session
+	// has expired.
+	later := start + int64(sessionTimeout) + 1
+	if err := kv.apply(appendOp(1, 2, "b", later)).err; err != ErrSessionExpired {
+		t.Fatalf("retry after the session expired returned %v, want %v", err, ErrSessionExpired)
+	}
+	kv.apply(appendOp(2, 1, "b", later))
+	if value := kv.data["k"]; value != "abb" {
+		t.Fatalf("k is %q, want %q", value, "abb")
+	}
+}
+
+// A request applied under one leader and retried under the next is
+// applied once.
+func TestSessionDedupAcrossLeaders(t *testing.T) {
+	c := makeCluster(t, 3, -1, false)
+	defer c.cleanup()
+
+	args := PutAppendArgs{Key: "k", Value: "x", Op: "Append", ClientId: 42, Seq: 1}
+	first := c.leader()
+	reply := PutAppendReply{}
+	c.kvs[first].PutAppend(&args, &reply)
+	if reply.Err != OK {
+		t.Fatalf("Append on leader %v returned %v", first, reply.Err)
+	}
+
+	// The reply got lost, and the client tries again with the next
+	// leader.
+	c.connect(first, false)
+	var second int
+	for second = c.leader(); second == first; second = c.leader() {
+		time.Sleep(100 * time.Millisecond)
+	}
+	reply = PutAppendReply{}
+	c.kvs[second].PutAppend(&args, &reply)
+	if reply.Err != OK {
+		t.Fatalf("retried Append on leader %v returned %v", second, reply.Err)
+	}
+	c.connect(first, true)
+
+	if value := c.makeClerk().Get("k"); value != "x" {
+		t.Fatalf("k is %q, want %q", value, "x")
+	}
+}
@@ -0,0 +1,144 @@
+package kvraft
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBasic(t *testing.T) {
+	c := makeCluster(t, 3, -1, false)
+	defer c.cleanup()
+
+	ck := c.makeClerk()
+	if value := ck.Get("a"); value != "" {
+		t.Fatalf("Get of a missing key returned %q", value)
+	}
+	ck.Put("a", "1")
+	ck.Append("a", "2")
+	ck.Append("b", "3")
+	if value := ck.Get("a"); value != "12" {
+		t.Fatalf("Get(a) returned %q, want %q", value, "12")
+	}
+	if value := ck.Get("b"); value != "3" {
+		t.Fatalf("Get(b) returned %q, want %q", value, "3")
+	}
+
+	// Another clerk sees the same state.
+	if value := c.makeClerk().Get("a"); value != "12" {
+		t.Fatalf("second clerk's Get(a) returned %q, want %q", value, "12")
+	}
+
+	c.runClients(5, 2*time.Second, nil)
+}
+
+// Clerks must find the new leader, and nothing started under the old one
+// may be lost or applied twice.
+func TestLeaderChange(t *testing.T) {
+	c := makeCluster(t, 3, -1, false)
+	defer c.cleanup()
+
+	ck := c.makeClerk()
+	ck.Put("a", "x")
+	leader := c.leader()
+	c.connect(leader, false)
+	ck.Append("a", "y")
+	c.connect(leader, true)
+	if value := ck.Get("a"); value != "xy" {
+		t.Fatalf("Get(a) returned %q after a leader change, want %q", value, "xy")
+	}
+
+	c.runClients(5, 4*time.Second, func(stop *int32) {
+		for atomic.LoadInt32(stop) == 0 {
+			leader := c.leader()
+			c.connect(leader, false)
+			time.Sleep(time.Second)
+			c.connect(leader, true)
+			time.Sleep(300 * time.Millisecond)
+		}
+	})
+}
+
+// Random servers are cut off and rejoin, on a network that loses and
+// delays messages.
+func TestPartitionUnreliable(t *testing.T) {
+	c := makeCluster(t, 5, -1, true)
+	defer c.cleanup()
+
+	c.runClients(5, 6*time.Second, func(stop *int32) {
+		r := rand.New(rand.NewSource(1))
+		for atomic.LoadInt32(stop) == 0 {
+			// Never more than a minority at once.
+			a, b := r.Intn(5), r.Intn(5)
+			c.connect(a, false)
+			c.connect(b, false)
+			time.Sleep(time.Duration(500+r.Intn(700)) * time.Millisecond)
+			c.connect(a, true)
+			c.connect(b, true)
+			time.Sleep(200 * time.Millisecond)
+		}
+	})
+}
+
+// The Raft state stays around maxraftstate, the rest going into
+// snapshots.
+func TestSnapshotSize(t *testing.T) {
+	const maxraftstate = 1000
+	c := makeCluster(t, 3, maxraftstate, false)
+	defer c.cleanup()
+
+	ck := c.makeClerk()
+	for i := 0; i < 200; i++ {
+		ck.Append("a", "x")
+	}
+	if value := ck.Get("a"); len(value) != 200 {
+		t.Fatalf("Get(a) returned %v bytes, want 200", len(value))
+	}
+	for i := 0; i < c.n; i++ {
+		if size := c.persisters[i].RaftStateSize(); size > 8*maxraftstate {
+			t.Fatalf("server %v has %v bytes of Raft state, maxraftstate is %v", i, size, maxraftstate)
+		}
+		if c.persisters[i].SnapshotSize() == 0 {
+			t.Fatalf("server %v never snapshotted", i)
+		}
+	}
+}
+
+// Servers crash and come back from their snapshots, all of them at
+// once at the end.
+func TestSnapshotRestart(t *testing.T) {
+	const maxraftstate = 1000
+	c := makeCluster(t, 3, maxraftstate, false)
+	defer c.cleanup()
+
+	c.runClients(3, 5*time.Second, func(stop *int32) {
+		r := rand.New(rand.NewSource(2))
+		for atomic.LoadInt32(stop) == 0 {
+			time.Sleep(time.Duration(700+r.Intn(500)) * time.Millisecond)
+			i := r.Intn(3)
+			c.crash(i)
+			time.Sleep(300 * time.Millisecond)
+			c.start(i)
+		}
+	})
+
+	ck := c.makeClerk()
+	ck.Put("k", "before")
+	for i := 0; i < 50; i++ {
+		ck.Append("k", ".")
+	}
+	want := ck.Get("k")
+	for i := 0; i < c.n; i++ {
+		c.crash(i)
+	}
+	for i := 0; i < c.n; i++ {
+		if c.persisters[i].SnapshotSize() == 0 {
+			t.Fatalf("server %v has no snapshot to restart from", i)
+		}
+		c.start(i)
+	}
+	if value := ck.Get("k"); value != want {
+		t.Fatalf("Get(k) returned %q after restarting every server, want %q", value, want)
+	}
+}
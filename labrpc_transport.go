@@ -0,0 +1,38 @@
+package raft
+
+import "6.824/labrpc"
+
+// Transport over the lab's simulated network. The tester registers each
+// Raft as a labrpc service, so requests arrive at the handlers by name.
+type labrpcTransport struct {
+	ends []*labrpc.ClientEnd
+}
+
+// Wraps the labrpc end points of all peers, in peers[] order.
+func MakeLabrpcTransport(ends []*labrpc.ClientEnd) Transport {
+	return &labrpcTransport{ends: ends}
+}
+
+func (t *labrpcTransport) NumPeers() int {
+	return len(t.ends)
+}
+
+func (t *labrpcTransport) RequestVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	return t.ends[server].Call("Raft.RequestVote", args, reply)
+}
+
+func (t *labrpcTransport) PreVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	return t.ends[server].Call("Raft.PreVote", args, reply)
+}
+
+func (t *labrpcTransport) AppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	return t.ends[server].Call("Raft.AppendEntries", args, reply)
+}
+
+func (t *labrpcTransport) InstallSnapshot(server int, args *InstallSnapshotArgs, reply *InstallSnapshotReply) bool {
+	return t.ends[server].Call("Raft.InstallSnapshot", args, reply)
+}
+
+func (t *labrpcTransport) TimeoutNow(server int, args *TimeoutNowArgs, reply *TimeoutNowReply) bool {
+	return t.ends[server].Call("Raft.TimeoutNow", args, reply)
+}
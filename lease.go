@@ -0,0 +1,56 @@
+package raft
+
+import (
+	"sort"
+	"time"
+)
+
+// Leader leases (Raft thesis §6.4.1): followers don't start an election
+// until an election timeout after they last heard from the leader, and
+// in lease mode they won't vote for anyone else before then either. So
+// once a majority has answered a heartbeat sent at time t, no other
+// leader can exist before t + MinElectionTimeout. Shaving off a bound on
+// clock drift, the leader can serve ReadIndex until then without
+// contacting anyone. Safety now depends on that bound holding. Lease
+// mode is set through Config.LeaseReads, so a peer that restarts
+// honors the lease from the moment it boots.
+
+// Whether this server is the leader, or heard from one within the
+// minimum election timeout.
+// Always call this while holding the raft lock.
+func (rf *Raft) heardFromLeader() bool {
+	return rf.state == LeaderState ||
+		rf.clock.Now().Sub(rf.lastHeartbeat) < rf.conf.MinElectionTimeout
+}
+
+// Whether a majority answered RPCs sent recently enough that no other
+// leader can have been elected since.
+// Always call this while holding the raft lock.
+func (rf *Raft) leaseValid() bool {
+	// A transfer deliberately lets the target skip its election timeout.
+	if !rf.conf.LeaseReads || rf.state != LeaderState || rf.transferring {
+		return false
+	}
+
+	config, _ := rf.log.lastConfig()
+	sent := []time.Time{}
+	for _, server := range config.servers() {
+		if server != rf.me && !rf.ackSent[server].IsZero() {
+			sent = append(sent, rf.ackSent[server])
+		}
+	}
+	sort.Slice(sent, func(i, j int) bool { return sent[i].After(sent[j]) })
+
+	// The lease starts at the latest send time such that everyone who
+	// answered something sent then or later forms a quorum (counting
+	// ourselves).
+	for _, leaseStart := range sent {
+		answered := func(server int) bool {
+			return server == rf.me || !rf.ackSent[server].Before(leaseStart)
+		}
+		if config.isQuorum(answered) {
+			return rf.clock.Now().Sub(leaseStart) < rf.conf.MinElectionTimeout-rf.conf.ClockDrift
+		}
+	}
+	return false
+}
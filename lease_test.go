@@ -0,0 +1,95 @@
+package raft
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+func makeLeaseCluster(t *testing.T, n int) *cluster {
+	c := makeCluster(t, n)
+	c.makePeer = func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+		conf := DefaultConfig()
+		conf.LeaseReads = true
+		conf.ClockDrift = 50 * time.Millisecond
+		rf, err := MakeWithConfig(MakeLabrpcTransport(ends), i, persister, applyCh, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rf
+	}
+	return c
+}
+
+func TestLeaseRead(t *testing.T) {
+	c := makeLeaseCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	time.Sleep(c.rafts[leader].conf.HeartbeatInterval * 2)
+
+	// Served from the lease, with nobody to confirm it.
+	c.disconnect(leader)
+	if _, err := c.rafts[leader].ReadIndex(context.Background()); err != nil {
+		t.Fatalf("lease read failed: %v", err)
+	}
+
+	// Until the lease runs out.
+	time.Sleep(c.rafts[leader].conf.MinElectionTimeout)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := c.rafts[leader].ReadIndex(ctx); err == nil {
+		t.Fatalf("read served after the lease expired")
+	}
+
+	c.connect(leader)
+	c.one(2, 3, true)
+}
+
+// A follower that restarts within the lease it granted mustn't vote for
+// a new leader while the old one may still serve reads from it.
+func TestLeaseHonoredAfterRestart(t *testing.T) {
+	c := makeLeaseCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	follower := (leader + 1) % 3
+	c.crash(follower)
+	c.start(follower)
+
+	rf := c.rafts[follower]
+	rf.mu.Lock()
+	term := rf.currentTerm
+	lastIndex, lastTerm := rf.log.lastIndex(), rf.log.lastTerm()
+	rf.mu.Unlock()
+
+	args := RequestVoteArgs{
+		Term:         term + 1,
+		CandidateId:  (leader + 2) % 3,
+		LastLogIndex: lastIndex + 10,
+		LastLogTerm:  lastTerm + 1,
+	}
+	reply := RequestVoteReply{}
+	rf.RequestVote(&args, &reply)
+	if reply.VoteGranted {
+		t.Fatalf("restarted follower voted within the lease")
+	}
+
+	// Once the lease is over it votes again.
+	time.Sleep(rf.conf.MinElectionTimeout)
+	rf.mu.Lock()
+	// It may have started an election of its own meanwhile.
+	args.Term = rf.currentTerm + 1
+	rf.mu.Unlock()
+	reply = RequestVoteReply{}
+	rf.RequestVote(&args, &reply)
+	if !reply.VoteGranted {
+		t.Fatalf("restarted follower refused a vote after the lease")
+	}
+}
@@ -0,0 +1,256 @@
+package linearizability
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// What the checker found for one partition of a history, for
+// visualizing it.
+type partitionInfo struct {
+	history []Operation
+	// The longest sequences of operations (indices into history) the
+	// search managed to linearize, with the state after each. When the
+	// partition is linearizable, the first one covers everything.
+	longest [][]step
+	ok      bool
+}
+
+type step struct {
+	op    int
+	state interface{}
+}
+
+// What CheckOperationsVerbose found, for VisualizePath.
+type LinearizationInfo struct {
+	partitions []partitionInfo
+}
+
+// Whether history is linearizable under model.
+func CheckOperations(model Model, history []Operation) bool {
+	result, _ := CheckOperationsVerbose(model, history, 0)
+	return result == Ok
+}
+
+// Checks history against model, giving up after timeout (no limit if
+// zero). The info it returns can be passed to VisualizePath.
+func CheckOperationsVerbose(model Model, history []Operation, timeout time.Duration) (CheckResult, LinearizationInfo) {
+	model = model.withDefaults()
+	partitions := model.Partition(history)
+
+	var kill int32
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&kill, 1)
+		})
+		defer timer.Stop()
+	}
+
+	result := Ok
+	info := LinearizationInfo{}
+	for _, partition := range partitions {
+		ok, longest, finished := checkPartition(model, partition, &kill)
+		info.partitions = append(info.partitions, partitionInfo{history: partition, longest: longest, ok: ok})
+		switch {
+		case !finished:
+			if result == Ok {
+				result = Unknown
+			}
+		case !ok:
+			result = Illegal
+		}
+	}
+	return result, info
+}
+
+// An entry in the time-ordered list of calls and returns the search
+// works through. A call's match is its return, a return's is nil.
+type entry struct {
+	call  bool
+	value interface{}
+	op    int
+	time  int64
+	match *entry
+	prev  *entry
+	next  *entry
+}
+
+// Builds the list of calls and returns in time order, headed by a
+// sentinel. At equal times calls go first, so operations that touch
+// count as concurrent.
+func makeEntries(history []Operation) *entry {
+	entries := []*entry{}
+	for i, op := range history {
+		ret := &entry{value: op.Output, op: i, time: op.Return}
+		call := &entry{call: true, value: op.Input, op: i, time: op.Call, match: ret}
+		entries = append(entries, call, ret)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].time != entries[j].time {
+			return entries[i].time < entries[j].time
+		}
+		return entries[i].call && !entries[j].call
+	})
+
+	head := &entry{op: -1}
+	last := head
+	for _, e := range entries {
+		last.next = e
+		e.prev = last
+		last = e
+	}
+	return head
+}
+
+// Takes a call and its return out of the list.
+func lift(e *entry) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	match := e.match
+	match.prev.next = match.next
+	if match.next != nil {
+		match.next.prev = match.prev
+	}
+}
+
+// Puts back a call and its return taken out by lift.
+func unlift(e *entry) {
+	match := e.match
+	match.prev.next = match
+	if match.next != nil {
+		match.next.prev = match
+	}
+	e.prev.next = e
+	e.next.prev = e
+}
+
+type bitset []uint64
+
+func newBitset(n int) bitset {
+	return make(bitset, (n+63)/64)
+}
+
+func (b bitset) clone() bitset {
+	return append(bitset{}, b...)
+}
+
+func (b bitset) set(i int) {
+	b[i/64] |= 1 << uint(i%64)
+}
+
+func (b bitset) clear(i int) {
+	b[i/64] &^= 1 << uint(i%64)
+}
+
+func (b bitset) hash() uint64 {
+	h := uint64(14695981039346656037)
+	for _, word := range b {
+		h = (h ^ word) * 1099511628211
+	}
+	return h
+}
+
+func (b bitset) equals(other bitset) bool {
+	for i := range b {
+		if b[i] != other[i] {
+			return false
+		}
+	}
+	return true
+}
+
+type cacheEntry struct {
+	linearized bitset
+	state      interface{}
+}
+
+// Searches for a linearization of one partition. Returns whether one
+// exists, the longest partial ones seen, and whether the search
+// finished before kill was set.
+func checkPartition(model Model, history []Operation, kill *int32) (bool, [][]step, bool) {
+	head := makeEntries(history)
+	linearized := newBitset(len(history))
+	cache := map[uint64][]cacheEntry{}
+	// Linearized calls so far, each with the state before it
+	type call struct {
+		entry *entry
+		state interface{}
+	}
+	calls := []call{}
+	state := model.Init()
+
+	longest := [][]step{}
+	longestLen := 0
+	// Saves the current partial linearization if it's the longest yet.
+	record := func() {
+		if len(calls) < longestLen {
+			return
+		}
+		path := make([]step, len(calls))
+		for i := range calls {
+			after := state
+			if i+1 < len(calls) {
+				after = calls[i+1].state
+			}
+			path[i] = step{op: calls[i].entry.op, state: after}
+		}
+		if len(calls) > longestLen {
+			longest = nil
+			longestLen = len(calls)
+		}
+		// A handful is enough to show what went wrong.
+		if len(longest) < 4 {
+			longest = append(longest, path)
+		}
+	}
+
+	e := head.next
+	for head.next != nil {
+		if atomic.LoadInt32(kill) != 0 {
+			return false, longest, false
+		}
+		if e.call {
+			ok, newState := model.Step(state, e.value, e.match.value)
+			if ok {
+				newLinearized := linearized.clone()
+				newLinearized.set(e.op)
+				if !cacheContains(model, cache, newLinearized, newState) {
+					hash := newLinearized.hash()
+					cache[hash] = append(cache[hash], cacheEntry{linearized: newLinearized, state: newState})
+					calls = append(calls, call{entry: e, state: state})
+					state = newState
+					linearized.set(e.op)
+					lift(e)
+					record()
+					e = head.next
+					continue
+				}
+			}
+			e = e.next
+			continue
+		}
+
+		// Reached a return whose call we couldn't linearize: backtrack.
+		if len(calls) == 0 {
+			return false, longest, true
+		}
+		top := calls[len(calls)-1]
+		calls = calls[:len(calls)-1]
+		e = top.entry
+		state = top.state
+		linearized.clear(e.op)
+		unlift(e)
+		e = e.next
+	}
+	return true, longest, true
+}
+
+func cacheContains(model Model, cache map[uint64][]cacheEntry, linearized bitset, state interface{}) bool {
+	for _, c := range cache[linearized.hash()] {
+		if c.linearized.equals(linearized) && model.Equal(c.state, state) {
+			return true
+		}
+	}
+	return false
+}
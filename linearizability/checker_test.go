@@ -0,0 +1,186 @@
+package linearizability
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegisterConcurrent(t *testing.T) {
+	// The first two Gets overlap the Put and each other, so the one that
+	// read 0 can go before the Put and the one that read 1 after it.
+	history := []Operation{
+		{ClientId: 0, Input: RegisterInput{RegisterPut, 1}, Call: 0, Output: 0, Return: 100},
+		{ClientId: 1, Input: RegisterInput{RegisterGet, 0}, Call: 10, Output: 1, Return: 30},
+		{ClientId: 2, Input: RegisterInput{RegisterGet, 0}, Call: 20, Output: 0, Return: 40},
+		{ClientId: 2, Input: RegisterInput{RegisterGet, 0}, Call: 50, Output: 1, Return: 60},
+	}
+	if !CheckOperations(RegisterModel(), history) {
+		t.Fatalf("legal register history judged not linearizable")
+	}
+}
+
+func TestKvHistory(t *testing.T) {
+	history := []Operation{
+		{ClientId: 0, Input: KvInput{KvPut, "x", "a"}, Call: 0, Output: KvOutput{}, Return: 20},
+		{ClientId: 1, Input: KvInput{KvAppend, "x", "b"}, Call: 10, Output: KvOutput{}, Return: 50},
+		{ClientId: 2, Input: KvInput{KvGet, "x", ""}, Call: 30, Output: KvOutput{"ab"}, Return: 40},
+		{ClientId: 0, Input: KvInput{KvAppend, "y", "c"}, Call: 30, Output: KvOutput{}, Return: 35},
+		{ClientId: 2, Input: KvInput{KvGet, "y", ""}, Call: 60, Output: KvOutput{"c"}, Return: 70},
+		{ClientId: 1, Input: KvInput{KvGet, "z", ""}, Call: 60, Output: KvOutput{""}, Return: 70},
+	}
+	if !CheckOperations(KvModel(), history) {
+		t.Fatalf("legal key/value history judged not linearizable")
+	}
+
+	// Both finished before the Get started, so it must see "ab", or "a"
+	// if the Append went first, but never just the Append.
+	history[2].Call, history[2].Return = 55, 58
+	history[2].Output = KvOutput{"a"}
+	if !CheckOperations(KvModel(), history) {
+		t.Fatalf("Append ordered before the Put judged not linearizable")
+	}
+	history[2].Output = KvOutput{"b"}
+	if result, _ := CheckOperationsVerbose(KvModel(), history, 0); result != Illegal {
+		t.Fatalf("Get missing a finished Put judged %v", result)
+	}
+}
+
+// A read of a value already overwritten when the read started.
+func staleReadHistory() []Operation {
+	return []Operation{
+		{ClientId: 0, Input: RegisterInput{RegisterPut, 1}, Call: 0, Output: 0, Return: 10},
+		{ClientId: 1, Input: RegisterInput{RegisterGet, 0}, Call: 11, Output: 1, Return: 15},
+		{ClientId: 2, Input: RegisterInput{RegisterGet, 0}, Call: 16, Output: 0, Return: 17},
+	}
+}
+
+func TestStaleRead(t *testing.T) {
+	if result, _ := CheckOperationsVerbose(RegisterModel(), staleReadHistory(), 0); result != Illegal {
+		t.Fatalf("stale read judged %v", result)
+	}
+}
+
+// An operation that never returned may or may not have taken effect.
+func TestNeverReturned(t *testing.T) {
+	history := []Operation{
+		{ClientId: 0, Input: RegisterInput{RegisterPut, 1}, Call: 0, Output: nil, Return: math.MaxInt64},
+		{ClientId: 1, Input: RegisterInput{RegisterGet, 0}, Call: 11, Output: 0, Return: 15},
+		{ClientId: 2, Input: RegisterInput{RegisterGet, 0}, Call: 16, Output: 1, Return: 17},
+	}
+	if !CheckOperations(RegisterModel(), history) {
+		t.Fatalf("Put that never returned can't have taken effect late")
+	}
+	history[1].Output = 1
+	if !CheckOperations(RegisterModel(), history) {
+		t.Fatalf("Put that never returned can't have taken effect early")
+	}
+
+	// But once seen, its effect can't be undone.
+	history = append(history, Operation{ClientId: 1, Input: RegisterInput{RegisterGet, 0}, Call: 20, Output: 0, Return: 21})
+	if CheckOperations(RegisterModel(), history) {
+		t.Fatalf("Put that never returned was undone")
+	}
+
+	// A Get that never returned could have read anything.
+	history = staleReadHistory()
+	history[2].Output, history[2].Return = nil, math.MaxInt64
+	if !CheckOperations(RegisterModel(), history) {
+		t.Fatalf("Get that never returned judged not linearizable")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	// A model slow enough that the search can't finish in time.
+	model := RegisterModel()
+	step := model.Step
+	model.Step = func(state interface{}, input interface{}, output interface{}) (bool, interface{}) {
+		time.Sleep(10 * time.Millisecond)
+		return step(state, input, output)
+	}
+	result, _ := CheckOperationsVerbose(model, staleReadHistory(), time.Millisecond)
+	if result != Unknown {
+		t.Fatalf("search out of time judged %v, want %v", result, Unknown)
+	}
+
+	// Without a timeout it runs to the end.
+	if result, _ := CheckOperationsVerbose(model, staleReadHistory(), 0); result != Illegal {
+		t.Fatalf("stale read judged %v with no timeout", result)
+	}
+}
+
+func TestVisualize(t *testing.T) {
+	model := RegisterModel()
+	_, info := CheckOperationsVerbose(model, staleReadHistory(), 0)
+	var buf bytes.Buffer
+	if err := Visualize(model, info, &buf); err != nil {
+		t.Fatal(err)
+	}
+	page := buf.String()
+
+	// The Put and the first Get are as far as the search got, the stale
+	// Get is the one that couldn't be placed.
+	for _, want := range []string{
+		"not linearizable",
+		"Longest partial linearization",
+		"<li>put(1) &rarr; state 1</li>",
+		"<li>get() -&gt; 1 &rarr; state 1</li>",
+		"#1 put(1)",
+		"#2 get() -&gt; 1",
+		`class="stuck"`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Fatalf("visualization has no %q:\n%v", want, page)
+		}
+	}
+	if strings.Contains(page, "get() -&gt; 0 &rarr;") {
+		t.Fatalf("stale Get shown as linearized:\n%v", page)
+	}
+}
+
+// Clients recorded with a Recorder against a register behind a mutex,
+// which is linearizable by construction.
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	var mu sync.Mutex
+	value := 0
+	var wg sync.WaitGroup
+	for client := 0; client < 5; client++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(client)))
+			for i := 0; i < 40; i++ {
+				if r.Intn(2) == 0 {
+					v := r.Intn(1000)
+					op := recorder.Invoke(client, RegisterInput{RegisterPut, v})
+					mu.Lock()
+					value = v
+					mu.Unlock()
+					op.Return(0)
+				} else {
+					op := recorder.Invoke(client, RegisterInput{RegisterGet, 0})
+					mu.Lock()
+					v := value
+					mu.Unlock()
+					op.Return(v)
+				}
+			}
+		}(client)
+	}
+	// One more that is never returned.
+	recorder.Invoke(5, RegisterInput{RegisterPut, 1000})
+	wg.Wait()
+
+	history := recorder.History()
+	if len(history) != 201 {
+		t.Fatalf("recorded %v operations, want 201", len(history))
+	}
+	if result, _ := CheckOperationsVerbose(RegisterModel(), history, 10*time.Second); result != Ok {
+		t.Fatalf("mutex register judged %v", result)
+	}
+}
@@ -0,0 +1,87 @@
+// Package linearizability checks that histories of concurrent client
+// operations against a service are linearizable: that there is an
+// order of the operations, consistent with when each was called and
+// returned, in which a sequential model of the service gives the
+// outputs the clients saw. It follows the approach of Porcupine (and
+// of Lowe's extension of the Wing & Gong algorithm), searching
+// depth-first for a linearization and memoizing the (operations
+// linearized, model state) pairs already explored.
+//
+// Record a history with a Recorder while clients run, then check it:
+//
+//	rec := linearizability.NewRecorder()
+//	op := rec.Invoke(client, linearizability.KvInput{Op: linearizability.KvPut, Key: "x", Value: "1"})
+//	...
+//	op.Return(linearizability.KvOutput{})
+//	result, info := linearizability.CheckOperationsVerbose(linearizability.KvModel(), rec.History(), 0)
+//	if result == linearizability.Illegal {
+//		linearizability.VisualizePath(linearizability.KvModel(), info, "history.html")
+//	}
+package linearizability
+
+import "fmt"
+
+// One call by a client and what it returned. Call and Return are
+// timestamps in any unit, as long as they are comparable across
+// clients. An operation that never returned has Return set to
+// math.MaxInt64 and a nil Output, since it may or may not have taken
+// effect; models should accept any output for it.
+type Operation struct {
+	ClientId int
+	Input    interface{}
+	Call     int64
+	Output   interface{}
+	Return   int64
+}
+
+// A sequential specification of a service.
+type Model struct {
+	// Splits a history into independent parts that are checked on
+	// their own, e.g. by key for a key/value store. Optional, but it
+	// makes checking exponentially cheaper.
+	Partition func(history []Operation) [][]Operation
+	// The state before any operation.
+	Init func() interface{}
+	// Whether the operation is allowed in state with the output seen,
+	// and if so the state after it. Must not modify state.
+	Step func(state interface{}, input interface{}, output interface{}) (bool, interface{})
+	// Whether two states are the same. Defaults to ==.
+	Equal func(state1, state2 interface{}) bool
+	// For the visualization. Default to fmt's %v.
+	DescribeOperation func(input interface{}, output interface{}) string
+	DescribeState     func(state interface{}) string
+}
+
+type CheckResult string
+
+const (
+	Ok      CheckResult = "Ok"
+	Illegal CheckResult = "Illegal"
+	// Checking ran out of time before finding either answer.
+	Unknown CheckResult = "Unknown"
+)
+
+// Fills in the optional functions left nil.
+func (m Model) withDefaults() Model {
+	if m.Partition == nil {
+		m.Partition = func(history []Operation) [][]Operation {
+			return [][]Operation{history}
+		}
+	}
+	if m.Equal == nil {
+		m.Equal = func(state1, state2 interface{}) bool {
+			return state1 == state2
+		}
+	}
+	if m.DescribeOperation == nil {
+		m.DescribeOperation = func(input interface{}, output interface{}) string {
+			return fmt.Sprintf("%v -> %v", input, output)
+		}
+	}
+	if m.DescribeState == nil {
+		m.DescribeState = func(state interface{}) string {
+			return fmt.Sprintf("%v", state)
+		}
+	}
+	return m
+}
@@ -0,0 +1,127 @@
+package linearizability
+
+import "fmt"
+
+type RegisterOp int
+
+const (
+	RegisterGet RegisterOp = iota
+	RegisterPut
+)
+
+// Input to RegisterModel. Value is what a Put writes. The output of a
+// Get is the int it read, the output of a Put is ignored.
+type RegisterInput struct {
+	Op    RegisterOp
+	Value int
+}
+
+// A single int register, starting at 0.
+func RegisterModel() Model {
+	return Model{
+		Init: func() interface{} {
+			return 0
+		},
+		Step: func(state interface{}, input interface{}, output interface{}) (bool, interface{}) {
+			in := input.(RegisterInput)
+			if in.Op == RegisterPut {
+				return true, in.Value
+			}
+			// A Get that never returned could have read anything.
+			return output == nil || output.(int) == state.(int), state
+		},
+		DescribeOperation: func(input interface{}, output interface{}) string {
+			in := input.(RegisterInput)
+			if in.Op == RegisterPut {
+				return fmt.Sprintf("put(%v)", in.Value)
+			}
+			return fmt.Sprintf("get() -> %v", describeOutput(output))
+		},
+	}
+}
+
+type KvOp int
+
+const (
+	KvGet KvOp = iota
+	KvPut
+	KvAppend
+)
+
+// Input to KvModel. Value is what a Put or Append writes.
+type KvInput struct {
+	Op    KvOp
+	Key   string
+	Value string
+}
+
+// Output of an operation on KvModel. Value is what a Get read, and is
+// ignored for Put and Append.
+type KvOutput struct {
+	Value string
+}
+
+// A map from strings to strings, every key starting out empty. Get
+// returns "" for a missing key, Append to a missing key acts like Put.
+// Histories are partitioned by key.
+func KvModel() Model {
+	return Model{
+		Partition: func(history []Operation) [][]Operation {
+			byKey := map[string][]Operation{}
+			keys := []string{}
+			for _, op := range history {
+				key := op.Input.(KvInput).Key
+				if _, ok := byKey[key]; !ok {
+					keys = append(keys, key)
+				}
+				byKey[key] = append(byKey[key], op)
+			}
+			partitions := [][]Operation{}
+			for _, key := range keys {
+				partitions = append(partitions, byKey[key])
+			}
+			return partitions
+		},
+		// The state is the value of the one key in the partition.
+		Init: func() interface{} {
+			return ""
+		},
+		Step: func(state interface{}, input interface{}, output interface{}) (bool, interface{}) {
+			in := input.(KvInput)
+			value := state.(string)
+			switch in.Op {
+			case KvPut:
+				return true, in.Value
+			case KvAppend:
+				return true, value + in.Value
+			default:
+				return output == nil || output.(KvOutput).Value == value, state
+			}
+		},
+		DescribeOperation: func(input interface{}, output interface{}) string {
+			in := input.(KvInput)
+			switch in.Op {
+			case KvPut:
+				return fmt.Sprintf("put(%q, %q)", in.Key, in.Value)
+			case KvAppend:
+				return fmt.Sprintf("append(%q, %q)", in.Key, in.Value)
+			default:
+				if output == nil {
+					return fmt.Sprintf("get(%q) -> ?", in.Key)
+				}
+				return fmt.Sprintf("get(%q) -> %q", in.Key, output.(KvOutput).Value)
+			}
+		},
+		DescribeState: func(state interface{}) string {
+			return fmt.Sprintf("%q", state)
+		},
+	}
+}
+
+// "?" for the output of an operation that never returned.
+func describeOutput(output interface{}) string {
+	if output == nil {
+		return "?"
+	}
+	return fmt.Sprintf("%v", output)
+}
@@ -0,0 +1,64 @@
+package linearizability
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Records the operations of concurrent clients as they happen, with
+// timestamps from one monotonic clock. Safe for concurrent use.
+type Recorder struct {
+	mu    sync.Mutex
+	start time.Time
+	ops   []*Operation
+}
+
+// An operation that has been called and not yet returned.
+type PendingOperation struct {
+	recorder *Recorder
+	op       *Operation
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{start: time.Now()}
+}
+
+func (r *Recorder) now() int64 {
+	return int64(time.Since(r.start))
+}
+
+// Records that client is calling an operation with input. Call this
+// just before sending the request.
+func (r *Recorder) Invoke(clientId int, input interface{}) *PendingOperation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	op := &Operation{ClientId: clientId, Input: input, Call: r.now(), Return: math.MaxInt64}
+	r.ops = append(r.ops, op)
+	return &PendingOperation{recorder: r, op: op}
+}
+
+// Records the output the operation returned. Call this as soon as the
+// reply arrives. An operation that is never returned (e.g. the client
+// gave up on it) stays open to the end of the history.
+func (p *PendingOperation) Return(output interface{}) {
+	r := p.recorder
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	p.op.Output = output
+	p.op.Return = r.now()
+}
+
+// The operations recorded so far.
+func (r *Recorder) History() []Operation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history := make([]Operation, len(r.ops))
+	for i, op := range r.ops {
+		history[i] = *op
+	}
+	return history
+}
@@ -0,0 +1,218 @@
+package linearizability
+
+import (
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// Writes an HTML page showing each partition of the history checked,
+// with the operations of each client laid out on a timeline. For a
+// partition that isn't linearizable, operations are marked with their
+// place in the longest partial linearization found, and the ones it
+// couldn't fit are in red, along with the order the search got to and
+// the state after each step.
+func Visualize(model Model, info LinearizationInfo, w io.Writer) error {
+	model = model.withDefaults()
+	page := visualizationPage{}
+	for i, partition := range info.partitions {
+		page.Partitions = append(page.Partitions, describePartition(model, i, partition))
+	}
+	// Failing partitions first, they're what the reader is after.
+	sort.SliceStable(page.Partitions, func(i, j int) bool {
+		return !page.Partitions[i].Ok && page.Partitions[j].Ok
+	})
+	return visualizationTemplate.Execute(w, page)
+}
+
+// Like Visualize, writing to the file at path.
+func VisualizePath(model Model, info LinearizationInfo, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := Visualize(model, info, file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+const (
+	visualizationWidth     = 1000
+	visualizationRowHeight = 28
+	visualizationMargin    = 80
+)
+
+type visualizationPage struct {
+	Partitions []visualizedPartition
+}
+
+type visualizedPartition struct {
+	Index  int
+	Ok     bool
+	Width  int
+	Height int
+	Rows   []visualizedRow
+	Ops    []visualizedOp
+	// The longest partial linearizations, as descriptions in order
+	Paths [][]visualizedStep
+}
+
+type visualizedRow struct {
+	Client int
+	Y      int
+}
+
+type visualizedOp struct {
+	X           int
+	Y           int
+	Width       int
+	Description string
+	// Place in the first longest partial linearization, 0 if not in it
+	Order   int
+	Pending bool
+}
+
+type visualizedStep struct {
+	Description string
+	State       string
+}
+
+func describePartition(model Model, index int, partition partitionInfo) visualizedPartition {
+	v := visualizedPartition{Index: index + 1, Ok: partition.ok, Width: visualizationWidth + 2*visualizationMargin}
+
+	// Scale time to the page, leaving operations that never returned
+	// running off the right edge.
+	start, end := int64(math.MaxInt64), int64(math.MinInt64)
+	for _, op := range partition.history {
+		start = min64(start, op.Call)
+		end = max64(end, op.Call)
+		if op.Return != math.MaxInt64 {
+			end = max64(end, op.Return)
+		}
+	}
+	span := float64(max64(end-start, 1))
+	x := func(t int64) int {
+		if t == math.MaxInt64 {
+			return visualizationMargin + visualizationWidth
+		}
+		return visualizationMargin + int(float64(t-start)/span*visualizationWidth)
+	}
+
+	rows := map[int]int{}
+	clients := []int{}
+	for _, op := range partition.history {
+		if _, ok := rows[op.ClientId]; !ok {
+			rows[op.ClientId] = 0
+			clients = append(clients, op.ClientId)
+		}
+	}
+	sort.Ints(clients)
+	for i, client := range clients {
+		rows[client] = i
+		v.Rows = append(v.Rows, visualizedRow{Client: client, Y: i*visualizationRowHeight + visualizationRowHeight/2 + 4})
+	}
+	v.Height = len(clients)*visualizationRowHeight + 10
+
+	order := map[int]int{}
+	if len(partition.longest) > 0 {
+		for i, s := range partition.longest[0] {
+			order[s.op] = i + 1
+		}
+	}
+	for i, op := range partition.history {
+		left := x(op.Call)
+		v.Ops = append(v.Ops, visualizedOp{
+			X:           left,
+			Y:           rows[op.ClientId]*visualizationRowHeight + 2,
+			Width:       max(x(op.Return)-left, 4),
+			Description: model.DescribeOperation(op.Input, op.Output),
+			Order:       order[i],
+			Pending:     op.Return == math.MaxInt64,
+		})
+	}
+
+	for _, path := range partition.longest {
+		steps := []visualizedStep{}
+		for _, s := range path {
+			op := partition.history[s.op]
+			steps = append(steps, visualizedStep{
+				Description: model.DescribeOperation(op.Input, op.Output),
+				State:       model.DescribeState(s.state),
+			})
+		}
+		v.Paths = append(v.Paths, steps)
+		if partition.ok {
+			// The one full linearization says it all.
+			break
+		}
+	}
+	return v
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var visualizationTemplate = template.Must(template.New("history").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Linearizability</title>
+<style>
+body { font-family: sans-serif; margin: 20px; }
+.ok { color: #2a7a2a; }
+.illegal { color: #b22222; }
+rect.linearized { fill: #cfe8cf; stroke: #2a7a2a; }
+rect.stuck { fill: #f4c7c7; stroke: #b22222; }
+rect.pending { stroke-dasharray: 4 2; }
+text { font-size: 11px; }
+ol { font-family: monospace; font-size: 12px; }
+</style>
+</head>
+<body>
+{{range $partition := .Partitions}}
+<h2>Partition {{.Index}}:
+{{if .Ok}}<span class="ok">linearizable</span>{{else}}<span class="illegal">not linearizable</span>{{end}}</h2>
+{{if not .Ok}}<p>Green operations are in the longest partial linearization found, numbered in its order. Red ones could not be placed after it.</p>{{end}}
+<svg width="{{.Width}}" height="{{.Height}}">
+{{range .Rows}}<text x="4" y="{{.Y}}">client {{.Client}}</text>
+{{end}}
+{{range .Ops}}<g>
+<title>{{.Description}}{{if .Pending}} (never returned){{end}}</title>
+<rect class="{{if .Order}}linearized{{else}}stuck{{end}}{{if .Pending}} pending{{end}}" x="{{.X}}" y="{{.Y}}" width="{{.Width}}" height="22" rx="3"></rect>
+<text x="{{.X}}" y="{{.Y}}" dx="3" dy="15">{{if .Order}}#{{.Order}} {{end}}{{.Description}}</text>
+</g>
+{{end}}
+</svg>
+{{range $path := .Paths}}
+<h3>{{if $partition.Ok}}Linearization{{else}}Longest partial linearization{{end}}</h3>
+<ol>
+{{range $path}}<li>{{.Description}} &rarr; state {{.State}}</li>
+{{end}}
+</ol>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,243 @@
+package raft
+
+import (
+	"bytes"
+
+	"6.824/labgob"
+)
+
+func init() {
+	labgob.Register(NoOp{})
+}
+
+// Log holds the entries that haven't been compacted into a snapshot.
+// Entries[0] stands in for the last entry covered by the snapshot (or
+// the empty first entry before any snapshot), so Entries[i] is the
+// entry at index LastIncludedIndex+i.
+type Log struct {
+	LastIncludedIndex int
+	// Configuration in effect as of LastIncludedIndex
+	LastIncludedConfig Configuration
+	Entries            []LogEntry
+
+	// Latest Configuration in the log and its index, rebuilt on demand
+	config      Configuration
+	configIndex int
+	configKnown bool
+
+	// Where entries are kept durable, if not with the rest of the raft
+	// state in the Persister.
+	store LogStore
+	// Set when compact() replaced the whole log, so nothing stored
+	// matches the snapshot any more.
+	storeStale bool
+}
+
+// The exported fields of Log, which are all that gets encoded. The
+// config cache and the store handle are rebuilt after a restart.
+type persistedLog struct {
+	LastIncludedIndex  int
+	LastIncludedConfig Configuration
+	Entries            []LogEntry
+}
+
+type LogEntry struct {
+	Term  int
+	Entry interface{}
+}
+
+// Appended by every new leader (Raft paper §8). Entries from earlier
+// terms only commit once an entry from the leader's own term does, so
+// this commits them without waiting for the next Start().
+type NoOp struct {
+	Term int
+}
+
+// Empty first log entry for indexing
+func makeLog(config Configuration) Log {
+	firstEntry := LogEntry{Entry: "", Term: 0}
+	return Log{LastIncludedIndex: 0, LastIncludedConfig: config, Entries: []LogEntry{firstEntry}}
+}
+
+func (l *Log) lastIncludedTerm() int {
+	return l.Entries[0].Term
+}
+
+func (l *Log) lastIndex() int {
+	return l.LastIncludedIndex + len(l.Entries) - 1
+}
+
+func (l *Log) lastTerm() int {
+	return l.Entries[len(l.Entries)-1].Term
+}
+
+// index must be in [LastIncludedIndex, lastIndex()]
+func (l *Log) entry(index int) LogEntry {
+	return l.Entries[index-l.LastIncludedIndex]
+}
+
+func (l *Log) term(index int) int {
+	return l.entry(index).Term
+}
+
+// Returns a copy of the entries from index on, safe to hand to an RPC
+// after the lock is released. Stops after maxEntries entries or once
+// they come to more than maxBytes gob-encoded, zero meaning no limit.
+// The entry at index is always included, however big.
+func (l *Log) batch(index int, maxEntries int, maxBytes int) []LogEntry {
+	suffix := l.Entries[index-l.LastIncludedIndex:]
+	if maxEntries > 0 && len(suffix) > maxEntries {
+		suffix = suffix[:maxEntries]
+	}
+	if maxBytes > 0 {
+		// One encoder for the whole batch, so the type descriptions are
+		// only counted once, as they would be on the wire.
+		w := new(bytes.Buffer)
+		e := labgob.NewEncoder(w)
+		for i := range suffix {
+			e.Encode(suffix[i])
+			if i > 0 && w.Len() > maxBytes {
+				suffix = suffix[:i]
+				break
+			}
+		}
+	}
+	entries := make([]LogEntry, len(suffix))
+	copy(entries, suffix)
+	return entries
+}
+
+// Deletes the entry at index and all that follow it.
+func (l *Log) truncate(index int) {
+	l.Entries = l.Entries[:index-l.LastIncludedIndex]
+	if l.configIndex >= index {
+		l.configKnown = false
+	}
+	if l.store != nil {
+		checkStore(l.store.TruncateFrom(index))
+	}
+}
+
+func (l *Log) append(entries ...LogEntry) {
+	start := l.lastIndex() + 1
+	l.Entries = append(l.Entries, entries...)
+	if l.store != nil {
+		checkStore(l.store.Append(start, entries))
+	}
+	for i, entry := range entries {
+		if config, ok := entry.Entry.(Configuration); ok && l.configKnown {
+			l.config = config
+			l.configIndex = start + i
+		}
+	}
+}
+
+// Discards every entry up to and including index, which is now covered
+// by a snapshot whose last entry has the given term, and under which
+// config was in effect. Entries after index are kept only if the log
+// agrees with the snapshot at index.
+func (l *Log) compact(index int, term int, config Configuration) {
+	if index <= l.LastIncludedIndex {
+		return
+	}
+
+	placeholder := LogEntry{Entry: "", Term: term}
+	if index <= l.lastIndex() && l.term(index) == term {
+		suffix := l.Entries[index-l.LastIncludedIndex+1:]
+		entries := make([]LogEntry, 0, len(suffix)+1)
+		entries = append(entries, placeholder)
+		l.Entries = append(entries, suffix...)
+	} else {
+		l.Entries = []LogEntry{placeholder}
+		l.storeStale = true
+	}
+	l.LastIncludedIndex = index
+	l.LastIncludedConfig = config
+	l.configKnown = false
+}
+
+// Drops stored entries that compact() made unnecessary. Only call this
+// once the snapshot is saved, so a crash in between still finds them.
+func (l *Log) trimStore() {
+	if l.store == nil {
+		return
+	}
+	if l.storeStale {
+		checkStore(l.store.TruncateFrom(0))
+		l.storeStale = false
+	} else {
+		checkStore(l.store.TruncateBefore(l.LastIncludedIndex))
+	}
+}
+
+// What the Persister keeps of the log: all of it, or only where it
+// starts when the entries live in a LogStore.
+func (l *Log) persisted() persistedLog {
+	entries := l.Entries
+	if l.store != nil {
+		entries = l.Entries[:1]
+	}
+	return persistedLog{
+		LastIncludedIndex:  l.LastIncludedIndex,
+		LastIncludedConfig: l.LastIncludedConfig,
+		Entries:            entries,
+	}
+}
+
+// Fills in the entries after LastIncludedIndex from the store, after
+// the rest of the log was read back from the Persister.
+func (l *Log) load() {
+	first, entries, err := l.store.Load()
+	checkStore(err)
+
+	last := first + len(entries) - 1
+	if len(entries) == 0 || last <= l.LastIncludedIndex {
+		return
+	}
+	if first > l.LastIncludedIndex+1 {
+		panic("log store: entries missing after the snapshot")
+	}
+	// A crash right after a snapshot replaced the whole log can leave
+	// entries behind that don't agree with it.
+	if first <= l.LastIncludedIndex && entries[l.LastIncludedIndex-first].Term != l.lastIncludedTerm() {
+		checkStore(l.store.TruncateFrom(0))
+		return
+	}
+	l.Entries = append(l.Entries[:1], entries[l.LastIncludedIndex+1-first:]...)
+}
+
+// Raft can't go on if its log isn't durable.
+func checkStore(err error) {
+	if err != nil {
+		panic("log store: " + err.Error())
+	}
+}
+
+// Configuration in effect at index: the latest Configuration entry at
+// or before it. index must be in [LastIncludedIndex, lastIndex()].
+func (l *Log) configAt(index int) Configuration {
+	for i := index; i > l.LastIncludedIndex; i-- {
+		if config, ok := l.entry(i).Entry.(Configuration); ok {
+			return config
+		}
+	}
+	return l.LastIncludedConfig
+}
+
+// Latest configuration in the log, committed or not, and the index of
+// its entry (LastIncludedIndex if it came with the snapshot).
+func (l *Log) lastConfig() (Configuration, int) {
+	if !l.configKnown {
+		l.config = l.LastIncludedConfig
+		l.configIndex = l.LastIncludedIndex
+		for i := l.lastIndex(); i > l.LastIncludedIndex; i-- {
+			if config, ok := l.entry(i).Entry.(Configuration); ok {
+				l.config = config
+				l.configIndex = i
+				break
+			}
+		}
+		l.configKnown = true
+	}
+	return l.config, l.configIndex
+}
@@ -0,0 +1,68 @@
+package raft
+
+import (
+	"strings"
+	"testing"
+)
+
+// Log with entries 1 through n, each carrying a size-byte string.
+func makeTestLog(n int, size int) Log {
+	l := makeLog(Configuration{})
+	for i := 1; i <= n; i++ {
+		l.append(LogEntry{Term: 1, Entry: strings.Repeat("x", size)})
+	}
+	return l
+}
+
+func TestLogBatchCount(t *testing.T) {
+	l := makeTestLog(10, 10)
+	if entries := l.batch(1, 3, 0); len(entries) != 3 {
+		t.Fatalf("batch capped at 3 entries has %v", len(entries))
+	}
+	if entries := l.batch(9, 3, 0); len(entries) != 2 {
+		t.Fatalf("batch of the last 2 entries has %v", len(entries))
+	}
+	if entries := l.batch(1, 0, 0); len(entries) != 10 {
+		t.Fatalf("uncapped batch has %v entries, want 10", len(entries))
+	}
+
+	// A copy, the log keeps its own entries.
+	entries := l.batch(1, 0, 0)
+	entries[0].Term = 5
+	if l.term(1) != 1 {
+		t.Fatalf("changing the batch changed the log")
+	}
+}
+
+func TestLogBatchBytes(t *testing.T) {
+	l := makeTestLog(10, 1000)
+	// Two entries and the type descriptions fit, a third doesn't.
+	if entries := l.batch(1, 0, 2500); len(entries) != 2 {
+		t.Fatalf("batch capped at 2500 bytes has %v 1000-byte entries", len(entries))
+	}
+	// Whichever cap is hit first wins.
+	if entries := l.batch(1, 1, 2500); len(entries) != 1 {
+		t.Fatalf("batch capped at 1 entry has %v", len(entries))
+	}
+	if entries := l.batch(1, 5, 1<<20); len(entries) != 5 {
+		t.Fatalf("batch capped at 5 entries has %v", len(entries))
+	}
+
+	// Same after compaction moves the indices.
+	l.compact(4, 1, Configuration{})
+	entries := l.batch(5, 0, 2500)
+	if len(entries) != 2 || entries[0].Entry != l.entry(5).Entry {
+		t.Fatalf("batch from 5 after compacting through 4 has %v entries", len(entries))
+	}
+}
+
+// An entry bigger than the cap still goes out, or the follower would
+// never get past it.
+func TestLogBatchOversized(t *testing.T) {
+	l := makeTestLog(3, 10000)
+	for index := 1; index <= 3; index++ {
+		if entries := l.batch(index, 0, 100); len(entries) != 1 {
+			t.Fatalf("batch of oversized entry %v has %v entries, want 1", index, len(entries))
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package raft
+
+// LogStore keeps log entries durable outside the Persister, so that
+// appending an entry writes just that entry instead of re-encoding the
+// whole log into the raft state. The Persister still holds currentTerm,
+// votedFor and where the log starts (LastIncludedIndex, alongside the
+// snapshot). On restart, readPersist fills in the entries after that
+// from the store.
+//
+// Every method is called while holding the raft lock, and a write must
+// be as durable as the store promises by the time it returns, since
+// Raft replies to RPCs right after.
+type LogStore interface {
+	// Writes entries so that entries[0] lands at index. Anything stored
+	// at or after index is replaced. If index would leave a gap after
+	// the last stored entry, everything stored is discarded first.
+	Append(index int, entries []LogEntry) error
+	// Deletes the entry at index and every one after it. Index 0
+	// empties the store.
+	TruncateFrom(index int) error
+	// Tells the store entries before index are covered by a snapshot.
+	// It may delete them, or keep some around.
+	TruncateBefore(index int) error
+	// Every stored entry, and the index of the first one.
+	Load() (first int, entries []LogEntry, err error)
+	Close() error
+}
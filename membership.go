@@ -0,0 +1,266 @@
+package raft
+
+import (
+	"context"
+
+	"6.824/labgob"
+)
+
+// Cluster membership changes through joint consensus (Raft paper §6).
+// The servers Make's transport can reach (peers[] with labrpc) are the
+// address book of every server that may ever be a member. The cluster
+// starts out with Config.InitialMembers voting, all of them by default,
+// and the rest are spares that sit idle until they are added.
+// A Configuration entry in the log says which of them currently vote,
+// and each server uses the latest one in its log, committed or not.
+//
+// To go from C_old to C_new the leader first appends C_old,new. While
+// that joint configuration is in effect, elections and commitment need
+// separate majorities of both C_old and C_new. Once C_old,new commits
+// the leader appends C_new, and once that commits a leader that isn't
+// part of C_new steps down.
+//
+// A configuration can also list learners. They get every entry like a
+// follower does and apply them, but they don't vote, never campaign and
+// don't count toward any majority, so adding or removing one is a single
+// configuration entry with no joint phase. A learner that has caught up
+// can be promoted to a voter, which goes through joint consensus like
+// AddServer.
+
+func init() {
+	labgob.Register(Configuration{})
+}
+
+// Voting members and learners, as indices into peers[]. OldMembers is
+// only set during joint consensus, when both it and Members need a
+// majority.
+type Configuration struct {
+	Members    []int
+	OldMembers []int
+	Learners   []int
+}
+
+// The configuration a new cluster of n peers starts with: members vote,
+// or every peer does if members is nil.
+func makeConfiguration(n int, members []int) Configuration {
+	if members != nil {
+		return Configuration{Members: append([]int{}, members...)}
+	}
+	members = []int{}
+	for server := 0; server < n; server++ {
+		members = append(members, server)
+	}
+	return Configuration{Members: members}
+}
+
+func (c Configuration) joint() bool {
+	return len(c.OldMembers) != 0
+}
+
+// Whether server votes in either half of the configuration.
+func (c Configuration) contains(server int) bool {
+	return contains(c.Members, server) || contains(c.OldMembers, server)
+}
+
+// Whether server receives entries, as a voter or a learner.
+func (c Configuration) replicates(server int) bool {
+	return c.contains(server) || contains(c.Learners, server)
+}
+
+// Every server that votes in either half of the configuration.
+func (c Configuration) servers() []int {
+	servers := append([]int{}, c.Members...)
+	for _, server := range c.OldMembers {
+		if !contains(servers, server) {
+			servers = append(servers, server)
+		}
+	}
+	return servers
+}
+
+// Whether the servers that agree form a majority of Members and, during
+// joint consensus, a majority of OldMembers as well.
+func (c Configuration) isQuorum(agrees func(server int) bool) bool {
+	if !isMajority(c.Members, agrees) {
+		return false
+	}
+	return !c.joint() || isMajority(c.OldMembers, agrees)
+}
+
+func isMajority(members []int, agrees func(server int) bool) bool {
+	count := 0
+	for _, server := range members {
+		if agrees(server) {
+			count++
+		}
+	}
+	return count > len(members)/2
+}
+
+func contains(servers []int, server int) bool {
+	for _, s := range servers {
+		if s == server {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds peers[server] as a voting member. Blocks until the new
+// configuration has committed.
+func (rf *Raft) AddServer(server int) error {
+	return rf.changeConfig(server, func(config *Configuration) error {
+		config.Learners = without(config.Learners, server)
+		if !contains(config.Members, server) {
+			config.Members = append(config.Members, server)
+		}
+		return nil
+	})
+}
+
+// Removes peers[server] from the configuration, whether it is a voting
+// member or a learner. Blocks until the new configuration has committed.
+// If server is this leader, it steps down once that happens.
+func (rf *Raft) RemoveServer(server int) error {
+	return rf.changeConfig(server, func(config *Configuration) error {
+		config.Members = without(config.Members, server)
+		config.Learners = without(config.Learners, server)
+		return nil
+	})
+}
+
+// Adds peers[server] as a learner, which starts receiving entries right
+// away. This is how a spare outside Config.InitialMembers joins without
+// holding up commitment while it catches up. Blocks until the new
+// configuration has committed.
+func (rf *Raft) AddLearner(server int) error {
+	return rf.changeConfig(server, func(config *Configuration) error {
+		if !config.replicates(server) {
+			config.Learners = append(config.Learners, server)
+		}
+		return nil
+	})
+}
+
+// Makes the learner peers[server] a voting member. Fails with
+// ErrLearnerBehind unless it has everything this leader has committed,
+// so the new voter can't hold up commitment while it catches up. Blocks
+// until the new configuration has committed.
+func (rf *Raft) PromoteLearner(server int) error {
+	return rf.changeConfig(server, func(config *Configuration) error {
+		if !contains(config.Learners, server) {
+			return ErrUnknownPeer
+		}
+		if rf.matchIndex[server] < rf.commitIndex {
+			return ErrLearnerBehind
+		}
+		config.Learners = without(config.Learners, server)
+		config.Members = append(config.Members, server)
+		return nil
+	})
+}
+
+// Returns a copy of servers without server.
+func without(servers []int, server int) []int {
+	remaining := []int{}
+	for _, s := range servers {
+		if s != server {
+			remaining = append(remaining, s)
+		}
+	}
+	return remaining
+}
+
+// Whether a and b hold the same servers, in any order.
+func sameServers(a []int, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, server := range a {
+		if !contains(b, server) {
+			return false
+		}
+	}
+	return true
+}
+
+// Builds the target configuration with change (called while holding
+// the raft lock), appends it, then waits for it to commit. When the
+// voting members change, C_old,new goes in first and the leader commits
+// C_new after it.
+func (rf *Raft) changeConfig(server int, change func(config *Configuration) error) error {
+	rf.mu.Lock()
+	if rf.state != LeaderState || rf.transferring {
+		rf.mu.Unlock()
+		return ErrNotLeader
+	}
+	if server < 0 || server >= rf.peers.NumPeers() {
+		rf.mu.Unlock()
+		return ErrUnknownPeer
+	}
+
+	// One change at a time, and only from a committed configuration.
+	config, configIndex := rf.log.lastConfig()
+	if config.joint() || configIndex > rf.commitIndex {
+		rf.mu.Unlock()
+		return ErrConfigChangeInProgress
+	}
+
+	newConfig := Configuration{
+		Members:  append([]int{}, config.Members...),
+		Learners: append([]int{}, config.Learners...),
+	}
+	if err := change(&newConfig); err != nil {
+		rf.mu.Unlock()
+		return err
+	}
+	if len(newConfig.Members) == 0 {
+		rf.mu.Unlock()
+		return ErrUnknownPeer
+	}
+	membersChanged := !sameServers(newConfig.Members, config.Members)
+	if !membersChanged && sameServers(newConfig.Learners, config.Learners) {
+		// Nothing to change.
+		rf.mu.Unlock()
+		return nil
+	}
+
+	entry := newConfig
+	if membersChanged {
+		entry.OldMembers = append([]int{}, config.Members...)
+	}
+	rf.log.append(LogEntry{Entry: entry, Term: rf.currentTerm})
+	rf.persist()
+	rf.triggerReplication()
+	rf.checkInvariants()
+	entryIndex := rf.log.lastIndex()
+	term := rf.currentTerm
+	rf.mu.Unlock()
+
+	// commitLoop appends C_new once C_old,new commits.
+	return rf.waitFor(context.Background(), term, func() bool {
+		config, configIndex := rf.log.lastConfig()
+		return configIndex >= entryIndex && !config.joint() && configIndex <= rf.commitIndex
+	})
+}
+
+// Called by the leader after commitIndex moves. Moves on from a
+// committed C_old,new to C_new, and steps down once a C_new that
+// doesn't include us commits.
+// Always call this while holding the raft lock.
+func (rf *Raft) advanceConfig() {
+	config, configIndex := rf.log.lastConfig()
+	if configIndex > rf.commitIndex {
+		return
+	}
+
+	if config.joint() {
+		newConfig := Configuration{Members: config.Members, Learners: config.Learners}
+		rf.log.append(LogEntry{Entry: newConfig, Term: rf.currentTerm})
+		rf.persist()
+		rf.triggerReplication()
+		rf.checkInvariants()
+	} else if !config.contains(rf.me) {
+		rf.revertToFollower()
+	}
+}
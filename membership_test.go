@@ -0,0 +1,167 @@
+package raft
+
+import (
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+// A cluster of n peers where only members vote to begin with, and the
+// rest are spares.
+func makeSpareCluster(t *testing.T, n int, members []int) *cluster {
+	c := makeCluster(t, n)
+	c.makePeer = func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+		conf := DefaultConfig()
+		conf.InitialMembers = members
+		rf, err := MakeWithConfig(MakeLabrpcTransport(ends), i, persister, applyCh, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rf
+	}
+	return c
+}
+
+func TestSingleVoterElection(t *testing.T) {
+	for _, preVote := range []bool{false, true} {
+		c := makeCluster(t, 1)
+		c.makePeer = func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+			conf := DefaultConfig()
+			conf.PreVote = preVote
+			rf, err := MakeWithConfig(MakeLabrpcTransport(ends), i, persister, applyCh, conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return rf
+		}
+		c.begin()
+
+		c.checkOneLeader()
+		c.one(1, 1, false)
+
+		// And again after a restart.
+		c.crash(0)
+		c.start(0)
+		c.connect(0)
+		c.checkOneLeader()
+		c.one(2, 1, false)
+		c.cleanup()
+	}
+}
+
+// A cluster shrunk down to one member by RemoveServer must still be able
+// to elect that member after it restarts.
+func TestRemoveDownToOne(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	for i := 0; i < 3; i++ {
+		if i != leader {
+			if err := c.rafts[leader].RemoveServer(i); err != nil {
+				t.Fatalf("RemoveServer(%v): %v", i, err)
+			}
+			c.crash(i)
+		}
+	}
+	c.one(2, 1, false)
+
+	c.crash(leader)
+	c.start(leader)
+	c.connect(leader)
+	if newLeader := c.checkOneLeader(); newLeader != leader {
+		t.Fatalf("leader is %v, want %v", newLeader, leader)
+	}
+	c.one(3, 1, false)
+}
+
+// A machine in the address book but not in the initial configuration
+// takes no part until it is added.
+func TestAddSpareServer(t *testing.T) {
+	c := makeSpareCluster(t, 4, []int{0, 1, 2})
+	c.begin()
+	defer c.cleanup()
+
+	// The spare neither campaigns nor gets entries, and the other three
+	// make progress without it.
+	c.one(1, 3, false)
+	if count, _ := c.nCommitted(1); count > 3 {
+		t.Fatalf("spare applied an entry before being added")
+	}
+	if term, _ := c.rafts[3].GetState(); term != 0 {
+		t.Fatalf("spare campaigned, at term %v", term)
+	}
+
+	leader := c.checkOneLeader()
+	if err := c.rafts[leader].AddServer(3); err != nil {
+		t.Fatalf("AddServer(3): %v", err)
+	}
+	c.one(2, 4, true)
+
+	// It now counts toward the majority: of four voters, the remaining
+	// two original members need it to elect a leader and commit.
+	c.crash(leader)
+	c.one(3, 3, true)
+}
+
+// A spare can start as a learner, catch up, then be promoted.
+func TestSpareJoinsAsLearner(t *testing.T) {
+	c := makeSpareCluster(t, 4, []int{0, 1, 2})
+	c.begin()
+	defer c.cleanup()
+
+	for i := 1; i <= 5; i++ {
+		c.one(i, 3, false)
+	}
+	leader := c.checkOneLeader()
+	if err := c.rafts[leader].AddLearner(3); err != nil {
+		t.Fatalf("AddLearner(3): %v", err)
+	}
+	c.one(6, 4, true)
+	c.mu.Lock()
+	for index, command := range c.committed {
+		if c.applied[3][index] != command {
+			t.Fatalf("learner has %v at index %v, want %v", c.applied[3][index], index, command)
+		}
+	}
+	c.mu.Unlock()
+
+	// It doesn't count toward the majority.
+	c.disconnect((leader + 1) % 3)
+	c.disconnect((leader + 2) % 3)
+	index, _, _ := c.rafts[leader].Start(7)
+	time.Sleep(time.Second)
+	if count, _ := c.nCommitted(index); count != 0 {
+		t.Fatalf("committed with only the leader and a learner")
+	}
+	c.connect((leader + 1) % 3)
+	c.connect((leader + 2) % 3)
+
+	leader = c.checkOneLeader()
+	if err := c.rafts[leader].PromoteLearner(3); err != nil {
+		t.Fatalf("PromoteLearner(3): %v", err)
+	}
+	c.one(8, 4, true)
+}
+
+// A change that swaps one member for another keeps the count but still
+// has to go through C_old,new.
+func TestSameServers(t *testing.T) {
+	tests := []struct {
+		a, b []int
+		want bool
+	}{
+		{[]int{0, 1, 2}, []int{2, 0, 1}, true},
+		{[]int{}, nil, true},
+		{[]int{0, 1, 2}, []int{0, 1, 3}, false},
+		{[]int{0, 1}, []int{0, 1, 2}, false},
+	}
+	for _, test := range tests {
+		if got := sameServers(test.a, test.b); got != test.want {
+			t.Fatalf("sameServers(%v, %v) = %v, want %v", test.a, test.b, got, test.want)
+		}
+	}
+}
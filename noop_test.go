@@ -0,0 +1,96 @@
+package raft
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+// An entry from the old leader's term reaches a majority but not its
+// commitIndex before it crashes. The new leader can't commit it by
+// counting replicas, only through its own NoOp, and nothing else is
+// ever Start()ed. Services see the NoOp as NoOpValid, never as a command.
+func TestNoOpCommitsEarlierTerm(t *testing.T) {
+	var mu sync.Mutex
+	noOps := make([]map[int]bool, 3)
+	c := makeCluster(t, 3)
+	c.makePeer = func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+		mu.Lock()
+		noOps[i] = map[int]bool{}
+		mu.Unlock()
+		inner := make(chan ApplyMsg)
+		go func() {
+			for msg := range inner {
+				if msg.CommandValid {
+					if _, ok := msg.Command.(NoOp); ok {
+						t.Errorf("peer %v applied NoOp at index %v as a command", i, msg.CommandIndex)
+					}
+				}
+				if msg.NoOpValid {
+					mu.Lock()
+					noOps[i][msg.NoOpIndex] = true
+					mu.Unlock()
+				}
+				applyCh <- msg
+			}
+		}()
+		return Make(ends, i, persister, inner)
+	}
+	c.begin()
+	defer c.cleanup()
+
+	first := c.one(101, 3, false)
+	mu.Lock()
+	for i := 0; i < 3; i++ {
+		if !noOps[i][first-1] {
+			t.Fatalf("peer %v never applied the first leader's NoOp at %v", i, first-1)
+		}
+	}
+	mu.Unlock()
+
+	leader := c.checkOneLeader()
+	ahead, behind := (leader+1)%3, (leader+2)%3
+	c.crash(leader)
+
+	// What the old leader would have sent before crashing.
+	rf := c.rafts[ahead]
+	rf.mu.Lock()
+	args := &AppendEntriesArgs{
+		Term:         rf.currentTerm,
+		LeaderId:     leader,
+		PrevLogIndex: rf.log.lastIndex(),
+		PrevLogTerm:  rf.log.lastTerm(),
+		LeaderCommit: rf.commitIndex,
+	}
+	args.Entries = []LogEntry{{Term: args.Term, Entry: 102}}
+	rf.mu.Unlock()
+	reply := &AppendEntriesReply{}
+	rf.AppendEntries(args, reply)
+	if !reply.Success {
+		t.Fatalf("follower %v rejected the old leader's entry", ahead)
+	}
+	index := args.PrevLogIndex + 1
+
+	// behind's log is shorter, so only ahead can win.
+	for iters := 0; iters < 50; iters++ {
+		if n, _ := c.nCommitted(index); n == 2 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if n, command := c.nCommitted(index); n != 2 || command != 102 {
+		t.Fatalf("earlier-term entry at %v committed on %v peers as %v", index, n, command)
+	}
+	if next := c.checkOneLeader(); next != ahead {
+		t.Fatalf("leader is %v, want %v", next, ahead)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	for _, i := range []int{ahead, behind} {
+		if !noOps[i][index+1] {
+			t.Fatalf("peer %v committed %v without the new leader's NoOp at %v", i, index, index+1)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package raft
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Defaults for Config durations left at zero.
+const (
+	defaultMinElectionTimeout = 300 * time.Millisecond
+	defaultMaxElectionTimeout = 550 * time.Millisecond
+	defaultHeartbeatInterval  = 100 * time.Millisecond
+	defaultCommitInterval     = 25 * time.Millisecond
+	defaultAppendTimeout      = 250 * time.Millisecond
+	defaultVoteTimeout        = 600 * time.Millisecond
+	defaultPollInterval       = 10 * time.Millisecond
+)
+
+// Tunables for a Raft peer, passed to MakeWithConfig. Durations left at
+// zero take their defaults. The election timeout and heartbeat interval
+// should be set the same way on every peer.
+type Config struct {
+	// Bounds of the randomized election timeout. A follower that hasn't
+	// heard from a leader for this long starts an election.
+	MinElectionTimeout time.Duration
+	MaxElectionTimeout time.Duration
+	// How often the leader sends heartbeats. Must be well under
+	// MinElectionTimeout, or followers time out between heartbeats.
+	HeartbeatInterval time.Duration
+	// How often the leader checks whether new entries are committed.
+	CommitInterval time.Duration
+	// How long the leader waits for an AppendEntries or InstallSnapshot
+	// reply before giving up on it.
+	AppendTimeout time.Duration
+	// How long a candidate waits for votes before giving up on the
+	// election.
+	VoteTimeout time.Duration
+	// How often ReadIndex, membership changes and TransferLeadership
+	// check on what they are waiting for.
+	PollInterval time.Duration
+
+	// Most entries the leader sends in one AppendEntries, zero meaning
+	// no limit.
+	MaxAppendEntries int
+	// Rough cap on the gob-encoded size of the entries in one
+	// AppendEntries, zero meaning no limit. An entry bigger than this
+	// still goes out, alone.
+	MaxAppendBytes int
+	// AppendEntries the leader may have outstanding to one follower at
+	// once, once it knows where the follower's log matches its own. One
+	// means waiting for each reply before sending more. Zero means the
+	// default, unlike the limits above: an unbounded window would let a
+	// leader queue up its whole log to a slow follower.
+	MaxInflightAppends int
+
+	// Run a pre-vote round before starting an election, see prevote.go.
+	PreVote bool
+
+	// Serve ReadIndex from a leader lease instead of a heartbeat round,
+	// see lease.go. Must be set the same way on every peer, since
+	// followers enforce the lease by refusing votes. ClockDrift bounds
+	// how much faster or slower any peer's clock may run over an
+	// election timeout.
+	LeaseReads bool
+	ClockDrift time.Duration
+
+	// Servers that vote in the configuration a new cluster starts with,
+	// as indices into the transport's peers. The rest of the peers start
+	// out as spares: they don't vote or campaign until AddServer or
+	// AddLearner brings them in, so the transport can list machines that
+	// join later. Nil means every peer votes. Must be the same on every
+	// peer. Once there is a log or snapshot, the configuration comes
+	// from there instead.
+	InitialMembers []int
+
+	// Where log entries are kept durable (e.g. a WAL from OpenWAL), and
+	// the term and vote (e.g. from OpenFileStableStore). Either may be
+	// nil to keep that state in the Persister. The same stores must be
+	// handed back after a restart, along with the same persister.
+	LogStore    LogStore
+	StableStore StableStore
+
+	// Where the peer gets the time and waits for it, the real clock if
+	// nil. Tests can pass a FakeClock.
+	Clock Clock
+	// Randomizes the election timeout, seeded from the current time if
+	// nil. Each peer needs its own, a Source isn't safe to share.
+	Rand rand.Source
+	// Debug mode: checks Raft's safety properties across every peer
+	// sharing this checker, see InvariantChecker. Off if nil.
+	Checker *InvariantChecker
+}
+
+// Config that Make and MakeWithTransport use. A follower that is far
+// behind catches up in chunks small enough to make it within the
+// AppendEntries timeout.
+func DefaultConfig() Config {
+	return Config{
+		MinElectionTimeout: defaultMinElectionTimeout,
+		MaxElectionTimeout: defaultMaxElectionTimeout,
+		HeartbeatInterval:  defaultHeartbeatInterval,
+		CommitInterval:     defaultCommitInterval,
+		AppendTimeout:      defaultAppendTimeout,
+		VoteTimeout:        defaultVoteTimeout,
+		PollInterval:       defaultPollInterval,
+		MaxAppendEntries:   512,
+		MaxAppendBytes:     1 << 20,
+		MaxInflightAppends: 4,
+	}
+}
+
+// Fills in defaults for the durations and window left at zero, then
+// checks the config makes sense for a cluster of peers servers.
+func (conf *Config) validate(peers int) error {
+	defaults := DefaultConfig()
+	fill := func(d *time.Duration, def time.Duration) {
+		if *d == 0 {
+			*d = def
+		}
+	}
+	fill(&conf.MinElectionTimeout, defaults.MinElectionTimeout)
+	fill(&conf.MaxElectionTimeout, defaults.MaxElectionTimeout)
+	fill(&conf.HeartbeatInterval, defaults.HeartbeatInterval)
+	fill(&conf.CommitInterval, defaults.CommitInterval)
+	fill(&conf.AppendTimeout, defaults.AppendTimeout)
+	fill(&conf.VoteTimeout, defaults.VoteTimeout)
+	fill(&conf.PollInterval, defaults.PollInterval)
+	if conf.MaxInflightAppends == 0 {
+		conf.MaxInflightAppends = defaults.MaxInflightAppends
+	}
+
+	switch {
+	case conf.MinElectionTimeout < 0 || conf.HeartbeatInterval < 0 ||
+		conf.CommitInterval < 0 || conf.AppendTimeout < 0 || conf.VoteTimeout < 0 ||
+		conf.PollInterval < 0 || conf.ClockDrift < 0:
+		return fmt.Errorf("raft: config: negative duration")
+	case conf.MaxElectionTimeout <= conf.MinElectionTimeout:
+		// The randomized range is what keeps split votes from repeating.
+		return fmt.Errorf("raft: config: MaxElectionTimeout %v must be above MinElectionTimeout %v",
+			conf.MaxElectionTimeout, conf.MinElectionTimeout)
+	case conf.HeartbeatInterval*3 > conf.MinElectionTimeout:
+		// Leaves room for a lost heartbeat or two before anyone times out.
+		return fmt.Errorf("raft: config: HeartbeatInterval %v must be at most a third of MinElectionTimeout %v",
+			conf.HeartbeatInterval, conf.MinElectionTimeout)
+	case conf.MaxAppendEntries < 0 || conf.MaxAppendBytes < 0 || conf.MaxInflightAppends < 0:
+		return fmt.Errorf("raft: config: negative AppendEntries limit")
+	case conf.InitialMembers != nil && len(conf.InitialMembers) == 0:
+		return fmt.Errorf("raft: config: InitialMembers is empty")
+	case conf.LeaseReads && conf.ClockDrift >= conf.MinElectionTimeout:
+		// The lease would never be valid.
+		return fmt.Errorf("raft: config: ClockDrift %v must be under MinElectionTimeout %v",
+			conf.ClockDrift, conf.MinElectionTimeout)
+	}
+	for i, server := range conf.InitialMembers {
+		if server < 0 || server >= peers || contains(conf.InitialMembers[:i], server) {
+			return fmt.Errorf("raft: config: InitialMembers has unknown or repeated peer %v", server)
+		}
+	}
+	return nil
+}
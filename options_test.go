@@ -0,0 +1,113 @@
+package raft
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+func TestConfigInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(conf *Config)
+		want   string
+	}{
+		{"heartbeat too slow", func(conf *Config) {
+			conf.HeartbeatInterval = conf.MinElectionTimeout / 2
+		}, "HeartbeatInterval"},
+		{"heartbeat equals election timeout", func(conf *Config) {
+			conf.HeartbeatInterval = conf.MinElectionTimeout
+		}, "HeartbeatInterval"},
+		{"min above max", func(conf *Config) {
+			conf.MinElectionTimeout, conf.MaxElectionTimeout = 500*time.Millisecond, 400*time.Millisecond
+		}, "MaxElectionTimeout"},
+		{"no randomized range", func(conf *Config) {
+			conf.MaxElectionTimeout = conf.MinElectionTimeout
+		}, "MaxElectionTimeout"},
+		{"max below default min", func(conf *Config) {
+			conf.MinElectionTimeout = 0
+			conf.MaxElectionTimeout = 200 * time.Millisecond
+		}, "MaxElectionTimeout"},
+		{"negative heartbeat", func(conf *Config) {
+			conf.HeartbeatInterval = -time.Millisecond
+		}, "negative duration"},
+		{"negative poll interval", func(conf *Config) {
+			conf.PollInterval = -time.Millisecond
+		}, "negative duration"},
+		{"negative clock drift", func(conf *Config) {
+			conf.ClockDrift = -time.Millisecond
+		}, "negative duration"},
+		{"negative window", func(conf *Config) {
+			conf.MaxInflightAppends = -1
+		}, "AppendEntries limit"},
+		{"negative byte cap", func(conf *Config) {
+			conf.MaxAppendBytes = -1
+		}, "AppendEntries limit"},
+		{"empty InitialMembers", func(conf *Config) {
+			conf.InitialMembers = []int{}
+		}, "InitialMembers is empty"},
+		{"unknown member", func(conf *Config) {
+			conf.InitialMembers = []int{0, 3}
+		}, "unknown or repeated peer 3"},
+		{"repeated member", func(conf *Config) {
+			conf.InitialMembers = []int{1, 1}
+		}, "unknown or repeated peer 1"},
+		{"lease never valid", func(conf *Config) {
+			conf.LeaseReads = true
+			conf.ClockDrift = conf.MinElectionTimeout
+		}, "ClockDrift"},
+	}
+	for _, test := range tests {
+		conf := DefaultConfig()
+		test.change(&conf)
+		err := conf.validate(3)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Fatalf("%v: validate returned %v, want an error about %v", test.name, err, test.want)
+		}
+
+		conf = DefaultConfig()
+		test.change(&conf)
+		rf, err := MakeWithConfig(MakeLabrpcTransport(make([]*labrpc.ClientEnd, 3)), 0, MakePersister(), make(chan ApplyMsg), conf)
+		if rf != nil || err == nil {
+			t.Fatalf("%v: MakeWithConfig made a peer", test.name)
+		}
+	}
+}
+
+func TestConfigDefaults(t *testing.T) {
+	// Zero durations and a zero window take the defaults, zero batch
+	// limits stay unlimited.
+	var conf Config
+	if err := conf.validate(3); err != nil {
+		t.Fatal(err)
+	}
+	defaults := DefaultConfig()
+	if conf.MinElectionTimeout != defaults.MinElectionTimeout ||
+		conf.MaxElectionTimeout != defaults.MaxElectionTimeout ||
+		conf.HeartbeatInterval != defaults.HeartbeatInterval ||
+		conf.PollInterval != defaults.PollInterval {
+		t.Fatalf("zero durations validated to %+v", conf)
+	}
+	if conf.MaxInflightAppends != defaults.MaxInflightAppends {
+		t.Fatalf("zero window validated to %v, want %v", conf.MaxInflightAppends, defaults.MaxInflightAppends)
+	}
+	if conf.MaxAppendEntries != 0 || conf.MaxAppendBytes != 0 {
+		t.Fatalf("zero batch limits validated to %v entries and %v bytes", conf.MaxAppendEntries, conf.MaxAppendBytes)
+	}
+
+	// Valid edge cases.
+	for _, change := range []func(conf *Config){
+		func(conf *Config) { conf.HeartbeatInterval = conf.MinElectionTimeout / 3 },
+		func(conf *Config) { conf.MaxElectionTimeout = conf.MinElectionTimeout + 1 },
+		func(conf *Config) { conf.InitialMembers = []int{2} },
+		func(conf *Config) { conf.MaxInflightAppends = 1 },
+	} {
+		conf := DefaultConfig()
+		change(&conf)
+		if err := conf.validate(3); err != nil {
+			t.Fatalf("valid config rejected: %v", err)
+		}
+	}
+}
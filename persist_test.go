@@ -0,0 +1,143 @@
+package raft
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestPersistRestartAll(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	for i := 1; i <= 10; i++ {
+		c.one(i, 3, false)
+	}
+
+	for i := 0; i < 3; i++ {
+		c.crash(i)
+	}
+	for i := 0; i < 3; i++ {
+		c.start(i)
+		c.connect(i)
+	}
+
+	// Nothing is applied again until the new leader commits an entry of
+	// its own, after which every peer must replay the same log.
+	c.one(11, 3, true)
+	for command := 1; command <= 10; command++ {
+		found := false
+		for index := 1; index <= 20; index++ {
+			if count, applied := c.nCommitted(index); applied == command {
+				if count != 3 {
+					t.Fatalf("%v applied by %v peers after restart, want 3", command, count)
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("%v lost in the restart", command)
+		}
+	}
+}
+
+func TestPersistFollowerRestart(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+
+	follower := (leader + 1) % 3
+	c.crash(follower)
+	for i := 2; i <= 5; i++ {
+		c.one(i, 2, false)
+	}
+	c.start(follower)
+	c.connect(follower)
+	c.one(6, 3, true)
+
+	// The leader can lose its place too.
+	leader = c.checkOneLeader()
+	c.crash(leader)
+	c.one(7, 2, true)
+	c.start(leader)
+	c.connect(leader)
+	c.one(8, 3, true)
+}
+
+// Crashes and restarts peers, leaders included, while entries are being
+// replicated, and checks that no entry that was applied anywhere is
+// ever lost or replaced.
+func TestPersistCrashMidReplication(t *testing.T) {
+	const servers = 5
+	c := makeCluster(t, servers)
+	c.begin()
+	defer c.cleanup()
+
+	r := rand.New(rand.NewSource(1))
+	command := 0
+	for iters := 0; iters < 100; iters++ {
+		for i := 0; i < servers; i++ {
+			c.mu.Lock()
+			rf := c.rafts[i]
+			c.mu.Unlock()
+			if rf != nil {
+				command++
+				rf.Start(command)
+			}
+		}
+
+		time.Sleep(time.Duration(r.Intn(50)) * time.Millisecond)
+
+		// Never more than a minority down, so progress is possible.
+		if r.Intn(10) < 3 {
+			down := 0
+			for i := 0; i < servers; i++ {
+				c.mu.Lock()
+				if c.rafts[i] == nil {
+					down++
+				}
+				c.mu.Unlock()
+			}
+			victim := r.Intn(servers)
+			c.mu.Lock()
+			up := c.rafts[victim] != nil
+			c.mu.Unlock()
+			if up && down < servers/2 {
+				c.crash(victim)
+			} else if !up {
+				c.start(victim)
+				c.connect(victim)
+			}
+		}
+	}
+
+	for i := 0; i < servers; i++ {
+		c.mu.Lock()
+		up := c.rafts[i] != nil
+		c.mu.Unlock()
+		if !up {
+			c.start(i)
+			c.connect(i)
+		}
+	}
+
+	// Once everyone has applied an entry after the last one, everything
+	// committed during the run must be at every peer.
+	last := c.one(-1, servers, true)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for index, command := range c.committed {
+		if index > last {
+			continue
+		}
+		for i := 0; i < servers; i++ {
+			if applied, ok := c.applied[i][index]; !ok || applied != command {
+				t.Fatalf("peer %v has %v at index %v, %v was committed there", i, applied, index, command)
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package raft
+
+// Pre-Vote (Raft thesis §9.6): before incrementing its term, a server
+// that has timed out asks the others whether they would vote for it.
+// Only if a majority would does it become a candidate, so a server that
+// was partitioned away can't force a healthy leader to step down with
+// an inflated term when it rejoins. Turned on with Config.PreVote.
+
+// PreVote RPC handler. Uses the RequestVote args with Term set to the
+// term the candidate would campaign in. Changes none of this server's
+// state, the reply only says whether it would grant a vote:
+//  1. Reply false if term <= currentTerm
+//  2. Reply false if we've heard from a leader within the minimum
+//     election timeout (or are the leader)
+//  3. Reply false unless the candidate's log is at least as
+//     up-to-date as ours (§5.4)
+func (rf *Raft) PreVote(args *RequestVoteArgs, reply *RequestVoteReply) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	reply.Term = rf.currentTerm
+
+	lastLogTerm := rf.log.lastTerm()
+	upToDate := lastLogTerm < args.LastLogTerm ||
+		(lastLogTerm == args.LastLogTerm && rf.log.lastIndex() <= args.LastLogIndex)
+
+	switch {
+	case args.Term <= rf.currentTerm:
+		reply.VoteGranted = false
+	case rf.heardFromLeader():
+		reply.VoteGranted = false
+	default:
+		reply.VoteGranted = upToDate
+	}
+}
+
+func (rf *Raft) sendPreVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	ok := rf.peers.PreVote(server, args, reply)
+	return ok
+}
+
+// Runs a pre-vote round for currentTerm + 1 and starts a real election
+// if a majority (counting ourselves) would grant their vote.
+func (rf *Raft) beginPreVote() {
+	// ----------------------v Locked to snapshot args
+	rf.mu.Lock()
+	args := RequestVoteArgs{
+		Term:         rf.currentTerm + 1,
+		CandidateId:  rf.me,
+		LastLogIndex: rf.log.lastIndex(),
+		LastLogTerm:  rf.log.lastTerm(),
+	}
+
+	config, _ := rf.log.lastConfig()
+	votes := map[int]bool{rf.me: true}
+	votesGathered := 1
+	votesExpected := len(config.servers())
+	if !config.contains(rf.me) {
+		votesExpected++
+	}
+	doneChan := make(chan bool, 1)
+	// With no other voters there are no replies to wait for.
+	if config.isQuorum(func(s int) bool { return votes[s] }) {
+		doneChan <- true
+	}
+
+	handlePreVotes := func(server int) {
+		reply := RequestVoteReply{}
+		rf.sendPreVote(server, &args, &reply)
+
+		// --------------------------------v Locked
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+
+		votesGathered++
+		if reply.VoteGranted {
+			votes[server] = true
+		} else if reply.Term > rf.currentTerm {
+			rf.updateTerm(reply.Term)
+			rf.revertToFollower()
+		}
+
+		// Stop waiting once we've won, or once every member has answered.
+		if config.isQuorum(func(s int) bool { return votes[s] }) || votesGathered == votesExpected {
+			select {
+			case doneChan <- true:
+			default:
+			}
+		}
+		// --------------------------------^ Locked
+	}
+
+	rf.sendToVoters(handlePreVotes)
+	rf.mu.Unlock()
+	// ----------------------^ Locked
+
+	select {
+	case <-doneChan:
+		// proceed
+	case <-rf.clock.After(rf.conf.MinElectionTimeout):
+		// proceed with however many votes made it back
+	}
+
+	rf.mu.Lock()
+	// A leader may have shown up while we were asking.
+	wonPreVote := config.isQuorum(func(s int) bool { return votes[s] }) &&
+		rf.currentTerm+1 == args.Term &&
+		rf.state != LeaderState &&
+		rf.timedOut
+
+	if !wonPreVote {
+		rf.mu.Unlock()
+		return
+	}
+	rf.becomeCandidate()
+	rf.mu.Unlock()
+
+	rf.beginElection(false)
+}
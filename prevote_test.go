@@ -0,0 +1,49 @@
+package raft
+
+import (
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+// A follower cut off from the others keeps timing out, but with
+// Pre-Vote it never wins a pre-vote round, so its term stays put and it
+// can't depose the leader when it comes back.
+func TestPreVotePartitionedFollower(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.makePeer = func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+		conf := DefaultConfig()
+		conf.PreVote = true
+		rf, err := MakeWithConfig(MakeLabrpcTransport(ends), i, persister, applyCh, conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rf
+	}
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	term, _ := c.rafts[leader].GetState()
+	follower := (leader + 1) % 3
+	c.disconnect(follower)
+
+	// Several election timeouts.
+	c.one(2, 2, false)
+	time.Sleep(2 * time.Second)
+	if followerTerm, _ := c.rafts[follower].GetState(); followerTerm != term {
+		t.Fatalf("partitioned follower moved from term %v to %v", term, followerTerm)
+	}
+
+	c.connect(follower)
+	c.one(3, 3, false)
+	time.Sleep(time.Second)
+	if newLeader := c.checkOneLeader(); newLeader != leader {
+		t.Fatalf("leader changed from %v to %v when the follower rejoined", leader, newLeader)
+	}
+	if newTerm, _ := c.rafts[leader].GetState(); newTerm != term {
+		t.Fatalf("term moved from %v to %v when the follower rejoined", term, newTerm)
+	}
+}
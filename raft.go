@@ -18,15 +18,13 @@ package raft
 //
 
 import (
-	//	"bytes"
-
-	"context"
+	"bytes"
 	"math/rand"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	//	"6.824/labgob"
+	"6.824/labgob"
 	"6.824/labrpc"
 )
 
@@ -47,25 +45,44 @@ const (
 // in part 2D you'll want to send other kinds of messages (e.g.,
 // snapshots) on the applyCh, but set CommandValid to false for these
 // other uses.
+//
+// committed membership changes arrive with ConfigValid set instead of
+// CommandValid, and the no-op each new leader appends arrives with
+// NoOpValid set. services can skip both, but they do take up a log
+// index.
 type ApplyMsg struct {
 	CommandValid bool
 	Command      interface{}
 	CommandIndex int
+	// Term the command was started in, so a service can tell whether the
+	// entry it started at CommandIndex is the one that committed there.
+	CommandTerm int
 
 	// For 2D:
 	SnapshotValid bool
 	Snapshot      []byte
 	SnapshotTerm  int
 	SnapshotIndex int
+
+	// For membership changes:
+	ConfigValid bool
+	Config      Configuration
+	ConfigIndex int
+
+	// For the no-op a new leader appends:
+	NoOpValid bool
+	NoOpIndex int
 }
 
 // A Go object implementing a single Raft peer.
 type Raft struct {
-	mu        sync.Mutex          // Lock to protect shared access to this peer's state
-	peers     []*labrpc.ClientEnd // RPC end points of all peers
-	persister *Persister          // Object to hold this peer's persisted state
-	me        int                 // this peer's index into peers[]
-	dead      int32               // set by Kill()
+	mu        sync.Mutex // Lock to protect shared access to this peer's state
+	peers     Transport  // RPC end points of all peers
+	persister *Persister // Object to hold this peer's persisted state
+	me        int        // this peer's index into peers[]
+	dead      int32      // set by Kill()
+	// Where currentTerm and votedFor are saved, if not in persister
+	stable StableStore
 
 	// Your data here (2A, 2B, 2C).
 	// Look at the paper's Figure 2 for a description of what
@@ -75,6 +92,35 @@ type Raft struct {
 	timedOut   bool
 	commitChan chan int
 
+	// Last time a valid AppendEntries/InstallSnapshot arrived from a leader
+	lastHeartbeat time.Time
+	// Tunables, see Config. Never changes after Make, read it without the lock.
+	conf  Config
+	clock Clock
+	// Election timeout jitter, only used by ticker
+	rand *rand.Rand
+	// Set while handing leadership to another peer, Start() is refused
+	transferring bool
+	// Per-peer triggers that wake the leader's maintainLogsLoop
+	replicateCond    []*sync.Cond
+	replicatePending []bool
+	// AppendEntries sent to each peer and not yet answered or timed out
+	inflight []int
+	// Set until a peer accepts an AppendEntries, while the leader is still
+	// looking for where its log matches. Only one AppendEntries at a time
+	// is sent to a probing peer.
+	probing []bool
+	// Leader's Only, Propose()d entries waiting to be applied, by index
+	futures map[int]*Future
+
+	// Snapshot received from the leader, waiting to be sent on applyCh
+	pendingSnapshot *ApplyMsg
+	// Set while a snapshot sent on applyCh awaits CondInstallSnapshot,
+	// so no later entries are applied in front of it.
+	installingSnapshot bool
+	// Configuration as of the pending snapshot's last included index
+	pendingSnapshotConfig Configuration
+
 	// Persisted
 	currentTerm int
 	votedFor    int
@@ -88,13 +134,11 @@ type Raft struct {
 	matchIndex []int
 	// Leader's Only, init to leader's last log index + 1
 	nextIndex []int
-}
-
-type Log []LogEntry
-
-type LogEntry struct {
-	Term  int
-	Entry interface{}
+	// Leader's Only, last time each peer answered an RPC in the current term
+	lastAck []time.Time
+	// Leader's Only, when the latest RPC each peer answered in the current
+	// term was sent. Cleared on stepping down.
+	ackSent []time.Time
 }
 
 // return currentTerm and whether this server
@@ -107,6 +151,8 @@ func (rf *Raft) GetState() (int, bool) {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 
+	rf.checkQuorum()
+
 	term = rf.currentTerm
 	isleader = (rf.state == LeaderState)
 
@@ -116,38 +162,123 @@ func (rf *Raft) GetState() (int, bool) {
 // save Raft's persistent state to stable storage,
 // where it can later be retrieved after a crash and restart.
 // see paper's Figure 2 for a description of what should be persistent.
+// Always call this while holding the raft lock, after any change to
+// the log and before replying to an RPC. Changes to currentTerm and
+// votedFor go through persistTermAndVote() instead.
 func (rf *Raft) persist() {
-	// Example:
-	// w := new(bytes.Buffer)
-	// e := labgob.NewEncoder(w)
-	// e.Encode(rf.xxx)
-	// e.Encode(rf.yyy)
-	// data := w.Bytes()
-	// rf.persister.SaveRaftState(data)
+	rf.persister.SaveRaftState(rf.encodeState())
+}
+
+// Saves currentTerm and votedFor, on their own if there's a StableStore.
+// Always call this while holding the raft lock, after changing either
+// and before replying to an RPC.
+func (rf *Raft) persistTermAndVote() {
+	if rf.stable == nil {
+		rf.persist()
+		return
+	}
+	if err := rf.stable.SetTermAndVote(rf.currentTerm, rf.votedFor); err != nil {
+		panic("stable store: " + err.Error())
+	}
+}
+
+// The term and vote are left out when the StableStore has them.
+func (rf *Raft) encodeState() []byte {
+	w := new(bytes.Buffer)
+	e := labgob.NewEncoder(w)
+	if rf.stable == nil {
+		e.Encode(rf.currentTerm)
+		e.Encode(rf.votedFor)
+	}
+	e.Encode(rf.log.persisted())
+	return w.Bytes()
 }
 
 // restore previously persisted state.
 func (rf *Raft) readPersist(data []byte) {
+	if rf.stable != nil {
+		currentTerm, votedFor, err := rf.stable.TermAndVote()
+		if err != nil {
+			panic("stable store: " + err.Error())
+		}
+		rf.currentTerm = currentTerm
+		rf.votedFor = votedFor
+	}
+
 	if data == nil || len(data) < 1 { // bootstrap without any state?
+		// The log store may still hold entries, e.g. when only the
+		// Persister was lost. Everything it has follows index 0.
+		if rf.log.store != nil {
+			rf.log.load()
+		}
 		return
 	}
-	// Example:
-	// r := bytes.NewBuffer(data)
-	// d := labgob.NewDecoder(r)
-	// var xxx
-	// var yyy
-	// if d.Decode(&xxx) != nil ||
-	//    d.Decode(&yyy) != nil {
-	//   error...
-	// } else {
-	//   rf.xxx = xxx
-	//   rf.yyy = yyy
-	// }
+	r := bytes.NewBuffer(data)
+	d := labgob.NewDecoder(r)
+	var currentTerm int
+	var votedFor int
+	var persisted persistedLog
+	if rf.stable == nil {
+		if d.Decode(&currentTerm) != nil ||
+			d.Decode(&votedFor) != nil {
+			panic("readPersist: failed to decode persisted raft state")
+		}
+		rf.currentTerm = currentTerm
+		rf.votedFor = votedFor
+	}
+	if d.Decode(&persisted) != nil {
+		panic("readPersist: failed to decode persisted raft state")
+	} else {
+		log := Log{
+			LastIncludedIndex:  persisted.LastIncludedIndex,
+			LastIncludedConfig: persisted.LastIncludedConfig,
+			Entries:            persisted.Entries,
+			store:              rf.log.store,
+		}
+		if log.store != nil {
+			log.load()
+		}
+		rf.log = log
+	}
 }
 
 // A service wants to switch to snapshot.  Only do so if Raft hasn't
 // have more recent info since it communicate the snapshot on applyCh.
+//
+// Nothing more is applied between sending a snapshot on applyCh and
+// this call, so a service that never calls it stops getting entries.
 func (rf *Raft) CondInstallSnapshot(lastIncludedTerm int, lastIncludedIndex int, snapshot []byte) bool {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	// Let applyChRoutine continue once the service has decided.
+	rf.installingSnapshot = false
+	go rf.kickApplyChan(rf.commitIndex)
+
+	// Entries through the snapshot were committed since the snapshot
+	// arrived. They are applied now that the flag is clear, so the
+	// service doesn't need it.
+	if lastIncludedIndex <= rf.commitIndex {
+		return false
+	}
+
+	config := rf.pendingSnapshotConfig
+	if lastIncludedIndex <= rf.log.lastIndex() && rf.log.term(lastIncludedIndex) == lastIncludedTerm {
+		config = rf.log.configAt(lastIncludedIndex)
+		// Our entries through the snapshot are the ones it holds.
+		rf.resolveFutures(lastIncludedIndex, nil)
+	} else {
+		// The whole log goes, and there's no telling which of our
+		// entries made it into the snapshot.
+		rf.failFutures(lastIncludedIndex+1, ErrTruncated)
+		rf.resolveFutures(lastIncludedIndex, ErrLeadershipLost)
+	}
+	rf.log.compact(lastIncludedIndex, lastIncludedTerm, config)
+	rf.commitIndex = lastIncludedIndex
+	rf.lastApplied = lastIncludedIndex
+	rf.persister.SaveStateAndSnapshot(rf.encodeState(), snapshot)
+	rf.log.trimStore()
+	rf.checkInvariants()
 
 	return true
 }
@@ -157,6 +288,82 @@ func (rf *Raft) CondInstallSnapshot(lastIncludedTerm int, lastIncludedIndex int,
 // service no longer needs the log through (and including)
 // that index. Raft should now trim its log as much as possible.
 func (rf *Raft) Snapshot(index int, snapshot []byte) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	// Already compacted, or the service is ahead of what's been applied.
+	if index <= rf.log.LastIncludedIndex || index > rf.lastApplied {
+		return
+	}
+
+	rf.log.compact(index, rf.log.term(index), rf.log.configAt(index))
+	rf.persister.SaveStateAndSnapshot(rf.encodeState(), snapshot)
+	rf.log.trimStore()
+	rf.checkInvariants()
+}
+
+// Sent by the leader when a follower needs entries that have already
+// been compacted into the leader's snapshot.
+type InstallSnapshotArgs struct {
+	Term               int
+	LeaderId           int
+	LastIncludedIndex  int
+	LastIncludedTerm   int
+	LastIncludedConfig Configuration
+	Data               []byte
+}
+
+type InstallSnapshotReply struct {
+	Term int
+}
+
+// 1. Reply immediately if term < currentTerm
+// 2. Hand the snapshot to the service through applyCh; the log is only
+// trimmed once the service calls CondInstallSnapshot.
+func (rf *Raft) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if args.Term > rf.currentTerm {
+		rf.updateTerm(args.Term)
+		rf.revertToFollower()
+	}
+
+	// Always set reply to current term
+	reply.Term = rf.currentTerm
+
+	if args.Term == rf.currentTerm && rf.state == CandidateState {
+		rf.revertToFollower()
+	}
+
+	// Step 1.
+	if args.Term < rf.currentTerm {
+		return
+	}
+
+	// Valid leader.
+	rf.timedOut = false
+	rf.lastHeartbeat = rf.clock.Now()
+
+	// Nothing new in an old snapshot.
+	if args.LastIncludedIndex <= rf.commitIndex {
+		return
+	}
+
+	// Step 2.
+	rf.pendingSnapshot = &ApplyMsg{
+		SnapshotValid: true,
+		Snapshot:      args.Data,
+		SnapshotTerm:  args.LastIncludedTerm,
+		SnapshotIndex: args.LastIncludedIndex,
+	}
+	rf.pendingSnapshotConfig = args.LastIncludedConfig
+	go rf.kickApplyChan(rf.commitIndex)
+}
+
+func (rf *Raft) sendInstallSnapshot(server int, args *InstallSnapshotArgs, reply *InstallSnapshotReply) bool {
+	ok := rf.peers.InstallSnapshot(server, args, reply)
+	return ok
 }
 
 // example RequestVote RPC arguments structure.
@@ -167,6 +374,9 @@ type RequestVoteArgs struct {
 	CandidateId  int
 	LastLogIndex int
 	LastLogTerm  int
+
+	// Set when the leader asked for this election with TimeoutNow
+	LeadershipTransfer bool
 }
 
 // example RequestVote RPC reply structure.
@@ -182,10 +392,30 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 	rf.mu.Lock()
 	defer rf.mu.Unlock()
 
+	// With leases, a current leader's followers must not help elect
+	// anyone else before its lease runs out (unless it asked them to).
+	if rf.conf.LeaseReads && !args.LeadershipTransfer && rf.heardFromLeader() {
+		reply.Term = rf.currentTerm
+		reply.VoteGranted = false
+		return
+	}
+
+	// A server removed from the configuration stops hearing from the
+	// leader and keeps campaigning (Raft paper §6). Ignore it while we
+	// still believe a current leader exists.
+	config, _ := rf.log.lastConfig()
+	if !config.contains(args.CandidateId) && !args.LeadershipTransfer && rf.heardFromLeader() {
+		reply.Term = rf.currentTerm
+		reply.VoteGranted = false
+		return
+	}
+
 	// Update term for new election if it's higher.
-	// Set votedFor to -1 for new term
+	// Set votedFor to -1 for new term, saved below along with the vote
+	term, votedFor := rf.currentTerm, rf.votedFor
 	if args.Term > rf.currentTerm {
-		rf.updateTerm(args.Term)
+		rf.currentTerm = args.Term
+		rf.votedFor = -1
 		rf.revertToFollower()
 	}
 
@@ -193,7 +423,7 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 	reply.Term = rf.currentTerm
 
 	// election restriction from Section 5.4
-	lastLogTerm := rf.log[len(rf.log)-1].Term
+	lastLogTerm := rf.log.lastTerm()
 	votedAlready := rf.votedFor != -1
 
 	switch {
@@ -207,17 +437,29 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 		reply.VoteGranted = true
 		rf.votedFor = args.CandidateId
 	case lastLogTerm == args.LastLogTerm:
-		if len(rf.log)-1 <= args.LastLogIndex {
+		if rf.log.lastIndex() <= args.LastLogIndex {
 			reply.VoteGranted = true
 			rf.votedFor = args.CandidateId
 		} else {
 			reply.VoteGranted = false
 		}
 	}
+
+	// Reset the election timer when granting a vote, so a leader that just
+	// stepped down doesn't race the candidate it voted for.
+	if reply.VoteGranted {
+		rf.timedOut = false
+	}
+
+	// Term and vote must be on stable storage before the reply goes out,
+	// in one save when both changed.
+	if rf.currentTerm != term || rf.votedFor != votedFor {
+		rf.persistTermAndVote()
+	}
 }
 
 // example code to send a RequestVote RPC to a server.
-// server is the index of the target server in rf.peers[].
+// server is the index of the target server in the cluster.
 // expects RPC arguments in args.
 // fills in *reply with RPC reply, so caller should
 // pass &reply.
@@ -244,7 +486,7 @@ func (rf *Raft) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) {
 // that the caller passes the address of the reply struct with &, not
 // the struct itself.
 func (rf *Raft) sendRequestVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
-	ok := rf.peers[server].Call("Raft.RequestVote", args, reply)
+	ok := rf.peers.RequestVote(server, args, reply)
 	return ok
 }
 
@@ -253,24 +495,36 @@ func (rf *Raft) sendRequestVote(server int, args *RequestVoteArgs, reply *Reques
 //  2. Vote for self
 //  3. Reset election timer (handled in ticker)
 //  4. Send request vote RPC
-func (rf *Raft) beginElection() {
+//
+// leadershipTransfer is set when the old leader asked for this election.
+func (rf *Raft) beginElection(leadershipTransfer bool) {
 	// ----------------------v Locked to snapshot args
 	rf.mu.Lock()
 	rf.currentTerm++
 	rf.votedFor = rf.me
+	rf.persistTermAndVote()
 
 	args := RequestVoteArgs{}
 	args.Term = rf.currentTerm
 	args.CandidateId = rf.me
-	args.LastLogIndex = len(rf.log)
-	args.LastLogTerm = rf.log[len(rf.log)-1].Term
-	rf.mu.Unlock()
-	// ----------------------^ Locked
-
-	numVotes := 1
-	votesNeeded := len(rf.peers)/2 + 1
+	args.LastLogIndex = rf.log.lastIndex()
+	args.LastLogTerm = rf.log.lastTerm()
+	args.LeadershipTransfer = leadershipTransfer
+
+	// Votes are counted against the configuration we campaign under,
+	// both halves of it during joint consensus.
+	config, _ := rf.log.lastConfig()
+	votes := map[int]bool{rf.me: true}
 	votesGathered := 1
-	var wg sync.WaitGroup
+	votesExpected := len(config.servers())
+	if !config.contains(rf.me) {
+		votesExpected++
+	}
+	waitChan := make(chan bool, 1)
+	// With no other voters there are no replies to wait for.
+	if config.isQuorum(func(s int) bool { return votes[s] }) {
+		waitChan <- true
+	}
 
 	handleVotes := func(server int) {
 		reply := RequestVoteReply{}
@@ -282,14 +536,18 @@ func (rf *Raft) beginElection() {
 		votesGathered++
 
 		if reply.VoteGranted && reply.Term == rf.currentTerm {
-			numVotes++
+			votes[server] = true
 		} else if reply.Term > rf.currentTerm {
 			rf.updateTerm(reply.Term)
 			rf.revertToFollower()
 		}
 
-		if votesGathered == votesNeeded {
-			wg.Done()
+		// Stop waiting once we've won, or once every member has answered.
+		if config.isQuorum(func(s int) bool { return votes[s] }) || votesGathered == votesExpected {
+			select {
+			case waitChan <- true:
+			default:
+			}
 		}
 		rf.mu.Unlock()
 		// --------------------------------^ Locked
@@ -298,24 +556,19 @@ func (rf *Raft) beginElection() {
 		// If it sees a new higher term from follower, convert to follower.
 	}
 
-	wg.Add(1)
-	rf.sendToPeers(handleVotes)
-	waitChan := make(chan bool, 1)
-
-	go func(ch chan bool) {
-		wg.Wait()
-		waitChan <- true
-	}(waitChan)
+	rf.sendToVoters(handleVotes)
+	rf.mu.Unlock()
+	// ----------------------^ Locked
 
 	select {
 	case <-waitChan:
 		//proceed
-	case <-time.After(600 * time.Millisecond):
+	case <-rf.clock.After(rf.conf.VoteTimeout):
 		return
 	}
 
 	rf.mu.Lock()
-	becomeLeaderCond := numVotes >= votesNeeded &&
+	becomeLeaderCond := config.isQuorum(func(s int) bool { return votes[s] }) &&
 		rf.currentTerm == args.Term &&
 		rf.state == CandidateState
 
@@ -330,7 +583,7 @@ func (rf *Raft) beginElection() {
 
 			rf.mu.Lock()
 			if reply.Term > rf.currentTerm {
-				rf.state = FollowerState
+				rf.revertToFollower()
 			}
 			rf.mu.Unlock()
 		}
@@ -374,8 +627,14 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 
 	// -------------v for updating leader status
 	if args.Term > rf.currentTerm {
+		// Stepping down fails our futures, but the ones whose entries
+		// step 3 overwrites fail with ErrTruncated instead.
+		futures := rf.futures
+		rf.futures = map[int]*Future{}
 		rf.updateTerm(args.Term)
 		rf.revertToFollower()
+		rf.futures = futures
+		defer rf.failFutures(0, ErrLeadershipLost)
 	}
 
 	// Always set reply to current term
@@ -394,63 +653,72 @@ func (rf *Raft) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply
 
 	// Valid leader.
 	rf.timedOut = false
+	rf.lastHeartbeat = rf.clock.Now()
 
 	// Step 2.
 	// (If you get an AppendEntries RPC with a prevLogIndex that points beyond the end of your log,
 	// you should handle it the same as if you did have that entry but the term did not match --
 	// reply false -- this is a special condition of step 2).
-	if args.PrevLogIndex >= len(rf.log) {
+	if args.PrevLogIndex > rf.log.lastIndex() {
 		reply.Success = false
-		reply.LogLength = len(rf.log)
+		reply.LogLength = rf.log.lastIndex() + 1
 		return
 	}
-	if rf.log[args.PrevLogIndex].Term != args.PrevLogTerm {
+	// prevLogIndex is inside our snapshot, have the leader resend from
+	// just after it.
+	if args.PrevLogIndex < rf.log.LastIncludedIndex {
 		reply.Success = false
-		reply.ConflictingTerm = rf.log[args.PrevLogIndex].Term
+		reply.LogLength = rf.log.LastIncludedIndex + 1
+		return
+	}
+	if rf.log.term(args.PrevLogIndex) != args.PrevLogTerm {
+		reply.Success = false
+		reply.ConflictingTerm = rf.log.term(args.PrevLogIndex)
 		return
 	}
 
 	// Step 3.
 	for i, entry := range args.Entries {
 		entryIndex := args.PrevLogIndex + i + 1
-		endLogIndex := len(rf.log) - 1 // Check to avoid indexing out of range
-		if entryIndex <= endLogIndex && entry.Term != rf.log[entryIndex].Term {
-			rf.log = rf.log[:entryIndex]
+		endLogIndex := rf.log.lastIndex() // Check to avoid indexing out of range
+		if entryIndex <= endLogIndex && entry.Term != rf.log.term(entryIndex) {
+			rf.log.truncate(entryIndex)
+			rf.failFutures(entryIndex, ErrTruncated)
+			rf.persist()
 			break
 		}
 	}
 
-	// Only append append the entries and update if they are going in the right spot!
-	// This comes up because old rpc's come in that might not truncate the log.
-	if len(rf.log)-1 == args.PrevLogIndex {
-		reply.Success = true
+	reply.Success = true
 
-		// Step 4. Append any entries not in the log
-		rf.log = append(rf.log, args.Entries...)
+	// Step 4. Append any entries not in the log. Everything before the
+	// first missing entry already matches, old rpc's that arrive late
+	// must not cut off entries that came after them.
+	for i := range args.Entries {
+		entryIndex := args.PrevLogIndex + i + 1
+		if entryIndex > rf.log.lastIndex() {
+			rf.log.append(args.Entries[i:]...)
+			rf.persist()
+			break
+		}
+	}
 
-		// Step 5. Only update NEW entry, not possibly incorrect logs ahead of leader.
-		if args.LeaderCommit > rf.commitIndex {
-			indexLastNewEntry := args.PrevLogIndex + len(args.Entries)
-			newCommitIndex := min(args.LeaderCommit, indexLastNewEntry)
+	// Step 5. Only update NEW entry, not possibly incorrect logs ahead of leader.
+	if args.LeaderCommit > rf.commitIndex {
+		indexLastNewEntry := args.PrevLogIndex + len(args.Entries)
+		newCommitIndex := min(args.LeaderCommit, indexLastNewEntry)
+		if newCommitIndex > rf.commitIndex {
 			rf.commitIndex = newCommitIndex
 			go rf.kickApplyChan(newCommitIndex)
 		}
 	}
-
+	rf.checkInvariants()
 }
 
+// The transport enforces its own deadline on the call.
 func (rf *Raft) sendAppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
-	replyChan := make(chan bool, 1)
-	ctx, cancel := context.WithTimeout(context.Background(), 600*time.Millisecond)
-	defer cancel()
-
-	replyChan <- rf.peers[server].Call("Raft.AppendEntries", args, reply)
-	select {
-	case <-ctx.Done():
-		return false
-	case ok := <-replyChan:
-		return ok
-	}
+	ok := rf.peers.AppendEntries(server, args, reply)
+	return ok
 }
 
 // the service using Raft (e.g. a k/v server) wants to start
@@ -466,100 +734,172 @@ func (rf *Raft) sendAppendEntries(server int, args *AppendEntriesArgs, reply *Ap
 // term. the third return value is true if this server believes it is
 // the leader.
 func (rf *Raft) Start(command interface{}) (int, int, bool) {
+	// ----------------------------------------v Locked
+	rf.mu.Lock()
+	index, term, isLeader := rf.start(command)
+	rf.mu.Unlock()
+	// ----------------------------------------^ Locked
+
+	return index, term, isLeader
+}
+
+// Always call this while holding the raft lock.
+func (rf *Raft) start(command interface{}) (int, int, bool) {
 	index := -1
 	term := -1
 	isLeader := true
 
-	// ----------------------------------------v Locked
-	rf.mu.Lock()
-	index = len(rf.log)
+	rf.checkQuorum()
+	index = rf.log.lastIndex() + 1
 	term = rf.currentTerm
-	isLeader = rf.state == LeaderState
+	isLeader = rf.state == LeaderState && !rf.transferring
 
 	if isLeader {
 		newEntry := LogEntry{Entry: command, Term: term}
-		rf.log = append(rf.log, newEntry)
+		rf.log.append(newEntry)
+		rf.persist()
+		rf.triggerReplication()
+		rf.checkInvariants()
 	}
 
-	rf.mu.Unlock()
-	// ----------------------------------------^ Locked
-
 	return index, term, isLeader
 }
 
-// Sends out AppendEntries to a given server and handles the reply.
-// Will keep retrying the server (and blocking) if no response is given quick enough.
-func (rf *Raft) sendLogUpdates(server int) {
-loop:
-	for !rf.killed() {
+// Sends one AppendEntries built by maintainLogsLoop to a given server
+// and handles the reply, waking the loop when it should send more: the
+// follower took the entries and there are more, or it rejected them and
+// nextIndex moved back. After no reply at all, the next heartbeat tick
+// retries.
+func (rf *Raft) sendLogUpdates(server int, args AppendEntriesArgs) {
+	reply := AppendEntriesReply{Term: 0, Success: false}
 
-		rf.mu.Lock()
-		isLeader := rf.state == LeaderState
-		rf.mu.Unlock()
+	sentAt := rf.clock.Now()
+	replyChan := make(chan bool, 1)
+	go func() {
+		replyChan <- rf.sendAppendEntries(server, &args, &reply)
+	}()
 
-		if isLeader {
-			rf.mu.Lock() // Lock to prevent inconsistency amongst args
-			args := AppendEntriesArgs{
-				Term:         rf.currentTerm,
-				LeaderId:     rf.me,
-				PrevLogIndex: rf.nextIndex[server] - 1,
-				PrevLogTerm:  rf.log[rf.nextIndex[server]-1].Term,
-				Entries:      rf.log[rf.nextIndex[server]:],
-				LeaderCommit: rf.commitIndex,
-			}
-			rf.mu.Unlock()
+	ok := false
+	select {
+	case <-rf.clock.After(rf.conf.AppendTimeout):
+		// Server probably unreachable, ignore the reply.
+	case ok = <-replyChan:
+	}
 
-			reply := AppendEntriesReply{Term: 0, Success: false}
+	// -------------------------------v Locked while handling reply
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
 
-			replyChan := make(chan bool, 1)
-			timeout := 250 * time.Millisecond
-			ctx, cancel := context.WithTimeout(context.Background(), timeout)
-			defer cancel()
-			go func(ctx context.Context) {
-				replyChan <- rf.sendAppendEntries(server, &args, &reply)
-			}(ctx)
+	rf.inflight[server]--
+	if !rf.leaderOf(args.Term) {
+		// nextIndex and matchIndex belong to a later term now.
+		return
+	}
 
-			select {
-			case <-ctx.Done():
-				// Server probably unreachable,
-				// then keep looping and ignore the reply.
-			case <-replyChan:
-				// -------------------------------v Locked while handling reply
-				rf.mu.Lock()
+	if !ok {
+		// The entries sent after these will find a gap, go back to
+		// where the follower is known to match. The next heartbeat
+		// retries.
+		if !rf.probing[server] {
+			rf.probing[server] = true
+			rf.nextIndex[server] = rf.matchIndex[server] + 1
+		}
+		return
+	}
+
+	if reply.Term == args.Term {
+		rf.recordAck(server, args.Term, sentAt)
+	}
+
+	if reply.Success && reply.Term == rf.currentTerm {
+		// for the purpose of updating commitIndex
+		rf.nextIndex[rf.me] = rf.log.lastIndex() + 1
+		rf.matchIndex[rf.me] = rf.log.lastIndex()
+
+		// Replies can arrive out of order, never move backwards.
+		rf.matchIndex[server] = max(rf.matchIndex[server], args.PrevLogIndex+len(args.Entries))
+		rf.nextIndex[server] = max(rf.nextIndex[server], rf.matchIndex[server]+1)
+		rf.probing[server] = false
+		if rf.log.lastIndex() >= rf.nextIndex[server] {
+			rf.signalReplication(server)
+		}
 
-				if reply.Success && reply.Term == rf.currentTerm {
-					// for the purpose of updating commitIndex
-					rf.nextIndex[rf.me] = len(rf.log)
-					rf.matchIndex[rf.me] = len(rf.log) - 1
-
-					rf.nextIndex[server] = args.PrevLogIndex + len(args.Entries) + 1
-					rf.matchIndex[server] = rf.nextIndex[server] - 1
-
-					// Exit the loop once the rpc call goes through, unlock mu before hand
-					// to avoid holding the lock forever
-					rf.mu.Unlock()
-					break loop
-
-				} else if rf.nextIndex[server] > 1 && rf.nextIndex[server] > rf.matchIndex[server]+1 && reply.Term == rf.currentTerm {
-					// decrement nextIndex, retry
-					switch {
-					case reply.LogLength != 0:
-						rf.nextIndex[server] = reply.LogLength
-					case reply.ConflictingTerm != 0:
-						for i := args.PrevLogIndex; i > rf.matchIndex[server]; i-- {
-							if rf.log[i].Term <= reply.ConflictingTerm {
-								rf.nextIndex[server] = i + 1
-								break
-							}
-						}
-					default:
-						rf.nextIndex[server]--
-					}
+	} else if args.PrevLogIndex > rf.matchIndex[server] && reply.Term == rf.currentTerm {
+		// Drop back to one AppendEntries at a time until the follower
+		// accepts one. nextIndex is moved back from where this request
+		// started, since later ones already pushed it ahead.
+		rf.probing[server] = true
+		switch {
+		case reply.LogLength != 0:
+			rf.nextIndex[server] = reply.LogLength
+		case reply.ConflictingTerm != 0:
+			rf.nextIndex[server] = rf.matchIndex[server] + 1
+			for i := args.PrevLogIndex; i > rf.matchIndex[server]; i-- {
+				if i <= rf.log.LastIncludedIndex {
+					// Ran into our snapshot, send that instead.
+					rf.nextIndex[server] = rf.log.LastIncludedIndex
+					break
+				}
+				if rf.log.term(i) <= reply.ConflictingTerm {
+					rf.nextIndex[server] = i + 1
+					break
 				}
-				rf.mu.Unlock()
-				// -------------------------------^ Locked
 			}
+		default:
+			rf.nextIndex[server] = args.PrevLogIndex
 		}
+		rf.signalReplication(server)
+	}
+}
+
+// Sends the leader's snapshot to a server whose nextIndex has fallen
+// behind the start of the log, then moves nextIndex past it.
+// Gives up after a timeout so maintainLogsLoop can retry. Returns whether
+// the server installed it.
+func (rf *Raft) sendSnapshotUpdate(server int) bool {
+	rf.mu.Lock()
+	if rf.state != LeaderState {
+		rf.mu.Unlock()
+		return false
+	}
+	args := InstallSnapshotArgs{
+		Term:               rf.currentTerm,
+		LeaderId:           rf.me,
+		LastIncludedIndex:  rf.log.LastIncludedIndex,
+		LastIncludedTerm:   rf.log.lastIncludedTerm(),
+		LastIncludedConfig: rf.log.LastIncludedConfig,
+		Data:               rf.persister.ReadSnapshot(),
+	}
+	rf.mu.Unlock()
+
+	reply := InstallSnapshotReply{}
+
+	sentAt := rf.clock.Now()
+	replyChan := make(chan bool, 1)
+	go func() {
+		replyChan <- rf.sendInstallSnapshot(server, &args, &reply)
+	}()
+
+	select {
+	case <-rf.clock.After(rf.conf.AppendTimeout):
+		// Server probably unreachable, let the caller retry.
+		return false
+	case ok := <-replyChan:
+		// -------------------------------v Locked while handling reply
+		rf.mu.Lock()
+		defer rf.mu.Unlock()
+		if ok && reply.Term == args.Term {
+			rf.recordAck(server, args.Term, sentAt)
+		}
+		if reply.Term > rf.currentTerm {
+			rf.updateTerm(reply.Term)
+			rf.revertToFollower()
+		} else if ok && reply.Term == rf.currentTerm && args.Term == rf.currentTerm {
+			rf.nextIndex[server] = args.LastIncludedIndex + 1
+			rf.matchIndex[server] = max(rf.matchIndex[server], args.LastIncludedIndex)
+			return true
+		}
+		return false
 	}
 }
 
@@ -574,7 +914,13 @@ loop:
 // should call killed() to check whether it should stop.
 func (rf *Raft) Kill() {
 	atomic.StoreInt32(&rf.dead, 1)
-	// fmt.Printf("%v, term:%v leader:%v commit:%v, loglength:%v\n", rf.me, rf.currentTerm, rf.state == LeaderState, rf.commitIndex, len(rf.log))
+	// Let the replication loops and applyChRoutine see it and exit.
+	rf.mu.Lock()
+	rf.triggerReplication()
+	rf.failFutures(0, ErrLeadershipLost)
+	go rf.kickApplyChan(rf.commitIndex)
+	rf.mu.Unlock()
+	// fmt.Printf("%v, term:%v leader:%v commit:%v, loglength:%v\n", rf.me, rf.currentTerm, rf.state == LeaderState, rf.commitIndex, rf.log.lastIndex()+1)
 
 }
 
@@ -595,14 +941,21 @@ func (rf *Raft) ticker() {
 		// then sleep for an election timeout cycle
 		// While the election starts, keep election timeout going.
 
-		// 250-500 ms Election Timeout
-		randTime := rand.Intn(250)
-		time.Sleep(time.Duration(300+randTime) * time.Millisecond)
+		// Election timeout, 300-550 ms by default
+		randTime := rf.rand.Int63n(int64(rf.conf.MaxElectionTimeout - rf.conf.MinElectionTimeout))
+		rf.clock.Sleep(rf.conf.MinElectionTimeout + time.Duration(randTime))
 
 		rf.mu.Lock()
-		if rf.timedOut && rf.state != LeaderState {
-			rf.becomeCandidate()
-			go rf.beginElection()
+		config, _ := rf.log.lastConfig()
+		// Servers outside the configuration never campaign.
+		if rf.timedOut && rf.state != LeaderState && config.contains(rf.me) {
+			if rf.conf.PreVote {
+				// Only becomes a candidate if it could win.
+				go rf.beginPreVote()
+			} else {
+				rf.becomeCandidate()
+				go rf.beginElection(false)
+			}
 		}
 
 		rf.timedOut = true
@@ -612,115 +965,249 @@ func (rf *Raft) ticker() {
 	}
 }
 
-// For leaders to send out heartbeats periodically
-func (rf *Raft) heartbeatLoop() {
-	for !rf.killed() {
+// Sends one heartbeat to server on behalf of the leader of term and
+// handles the reply. Returns true if the server acknowledged us as its
+// leader for that term.
+func (rf *Raft) sendHeartbeat(server int, term int) bool {
+	rf.mu.Lock()
+	if rf.state != LeaderState || rf.currentTerm != term {
+		// Stepped down before this goroutine ran.
+		rf.mu.Unlock()
+		return false
+	}
+	// Don't point before the snapshot, there's no term to send for it.
+	prevLogIndex := max(rf.nextIndex[server]-1, rf.log.LastIncludedIndex)
+	args := AppendEntriesArgs{
+		Term:         rf.currentTerm,
+		LeaderId:     rf.me,
+		PrevLogIndex: prevLogIndex,
+		PrevLogTerm:  rf.log.term(prevLogIndex),
+		Entries:      []LogEntry{},
+		LeaderCommit: rf.commitIndex,
+	}
+	rf.mu.Unlock()
 
-		rf.mu.Lock()
-		if rf.state == LeaderState {
-			// send append entries heartbeats every 100ms, forward requests
-			heartbeat := func(server int) {
-				rf.mu.Lock()
-				args := AppendEntriesArgs{
-					Term:         rf.currentTerm,
-					LeaderId:     rf.me,
-					PrevLogIndex: rf.nextIndex[server] - 1,
-					PrevLogTerm:  rf.log[rf.nextIndex[server]-1].Term,
-					Entries:      []LogEntry{},
-					LeaderCommit: rf.commitIndex,
-				}
-				rf.mu.Unlock()
+	reply := AppendEntriesReply{}
+	sentAt := rf.clock.Now()
+	ok := rf.sendAppendEntries(server, &args, &reply)
 
-				reply := AppendEntriesReply{}
-				rf.sendAppendEntries(server, &args, &reply)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
 
-				rf.mu.Lock()
-				if reply.Term > rf.currentTerm {
-					rf.revertToFollower()
-				}
-				rf.mu.Unlock()
-			}
+	acked := ok && reply.Term == args.Term
+	if acked {
+		rf.recordAck(server, args.Term, sentAt)
+	}
+	if ok && reply.Success && args.Term == rf.currentTerm {
+		// The follower's log matches ours through prevLogIndex.
+		rf.matchIndex[server] = max(rf.matchIndex[server], args.PrevLogIndex)
+	}
+	if reply.Term > rf.currentTerm {
+		rf.revertToFollower()
+	}
+	return acked
+}
+
+// For leaders to send out heartbeats periodically, for as long as they
+// lead term.
+func (rf *Raft) heartbeatLoop(term int) {
+	ticker := rf.clock.NewTicker(rf.conf.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for !rf.killed() {
+
+		rf.mu.Lock()
+		if !rf.leaderOf(term) {
+			rf.mu.Unlock()
+			return
+		}
 
-			rf.sendToPeers(heartbeat)
+		// send append entries heartbeats every HeartbeatInterval, forward requests
+		heartbeat := func(server int) {
+			rf.sendHeartbeat(server, term)
 		}
+		rf.sendToPeers(heartbeat)
+		// Followers whose last AppendEntries went unanswered get another try.
+		rf.triggerReplication()
+
+		// Step down if a majority stopped answering.
+		rf.checkQuorum()
 		rf.mu.Unlock()
 
-		time.Sleep(100 * time.Millisecond)
+		<-ticker.C()
 	}
 }
 
-// Leader periodically sends out append entries when follower logs aren't
-// up to date with leader's log.
-func (rf *Raft) maintainLogsLoop(server int) {
-	for !rf.killed() {
-		rf.mu.Lock()
-		isLeader := rf.state == LeaderState
-		rf.mu.Unlock()
+// Leader runs one per follower for as long as it leads term, sending
+// out append entries when the follower's log isn't up to date with the
+// leader's. Sleeps until triggerReplication() is called by Start(), a
+// heartbeat tick or a reply that calls for another try.
+//
+// Once the follower has accepted an AppendEntries, up to
+// Config.MaxInflightAppends are kept outstanding, and nextIndex moves past each
+// batch as it is sent rather than when it is answered. A rejection or a
+// lost request puts the follower back to probing, one request at a
+// time, until it accepts one again.
+func (rf *Raft) maintainLogsLoop(server int, term int) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
 
-		if isLeader {
-			rf.mu.Lock()
-			cond := len(rf.log)-1 >= rf.nextIndex[server]
-			rf.mu.Unlock()
+	for {
+		for !rf.replicatePending[server] && rf.leaderOf(term) && !rf.killed() {
+			rf.replicateCond[server].Wait()
+		}
+		// Leave the trigger set for the next leader's loop.
+		if !rf.leaderOf(term) || rf.killed() {
+			return
+		}
+		rf.replicatePending[server] = false
 
-			if cond {
-				rf.sendLogUpdates(server)
+		config, _ := rf.log.lastConfig()
+		if !config.replicates(server) {
+			continue
+		}
+
+		window := rf.conf.MaxInflightAppends
+		if rf.probing[server] {
+			window = 1
+		}
+		for rf.log.lastIndex() >= rf.nextIndex[server] && rf.inflight[server] < window {
+			if rf.nextIndex[server] <= rf.log.LastIncludedIndex {
+				// The entries it needs are only in the snapshot now. Wait
+				// for the requests in flight to settle before sending it.
+				if rf.inflight[server] > 0 {
+					break
+				}
+				rf.mu.Unlock()
+				again := rf.sendSnapshotUpdate(server)
+				rf.mu.Lock()
+
+				if again {
+					rf.replicatePending[server] = true
+				}
+				break
 			}
+
+			args := AppendEntriesArgs{
+				Term:         rf.currentTerm,
+				LeaderId:     rf.me,
+				PrevLogIndex: rf.nextIndex[server] - 1,
+				PrevLogTerm:  rf.log.term(rf.nextIndex[server] - 1),
+				Entries:      rf.log.batch(rf.nextIndex[server], rf.conf.MaxAppendEntries, rf.conf.MaxAppendBytes),
+				LeaderCommit: rf.commitIndex,
+			}
+			if !rf.probing[server] {
+				rf.nextIndex[server] += len(args.Entries)
+			}
+			rf.inflight[server]++
+			go rf.sendLogUpdates(server, args)
 		}
 	}
 }
 
-// Use this as a long running go routine to send applyCh messages when entries are committed
+// Use this as a long running go routine to send applyCh messages when entries are committed,
+// or when a snapshot arrives from the leader.
 func (rf *Raft) applyChRoutine(applyCh chan ApplyMsg) {
 	for !rf.killed() {
 		<-rf.commitChan
+		if rf.killed() {
+			return
+		}
 
 		rf.mu.Lock()
-		applyIndex := rf.lastApplied
-		commitIndex := rf.commitIndex
+		snapshotMsg := rf.pendingSnapshot
+		rf.pendingSnapshot = nil
+		if snapshotMsg != nil {
+			rf.installingSnapshot = true
+		}
 		rf.mu.Unlock()
 
-		for applyIndex < commitIndex {
+		if snapshotMsg != nil {
+			// CondInstallSnapshot kicks the channel again when it's done.
+			applyCh <- *snapshotMsg
+			continue
+		}
+
+		for {
 			rf.mu.Lock()
-			applyIndex++
+			if rf.installingSnapshot || rf.lastApplied >= rf.commitIndex {
+				rf.mu.Unlock()
+				break
+			}
+			applyIndex := rf.lastApplied + 1
+			command := rf.log.entry(applyIndex).Entry
 			applyMsg := ApplyMsg{
 				CommandValid: true,
-				Command:      rf.log[applyIndex].Entry,
+				Command:      command,
 				CommandIndex: applyIndex,
+				CommandTerm:  rf.log.term(applyIndex),
+			}
+			switch entry := applyMsg.Command.(type) {
+			case Configuration:
+				applyMsg = ApplyMsg{
+					ConfigValid: true,
+					Config:      entry,
+					ConfigIndex: applyIndex,
+				}
+			case NoOp:
+				applyMsg = ApplyMsg{
+					NoOpValid: true,
+					NoOpIndex: applyIndex,
+				}
 			}
 			rf.lastApplied++
+			if rf.conf.Checker != nil {
+				rf.conf.Checker.apply(rf.me, applyIndex, command)
+			}
+			// Still our entry, a different one would have failed the
+			// future when it overwrote ours.
+			future := rf.futures[applyIndex]
+			delete(rf.futures, applyIndex)
 			rf.mu.Unlock()
 
 			applyCh <- applyMsg
+
+			// Resolved once the service has the entry.
+			if future != nil {
+				future.resolve(nil)
+			}
 		}
 	}
 }
 
-func (rf *Raft) commitLoop() {
+// Runs for as long as the leader leads term.
+func (rf *Raft) commitLoop(term int) {
+	ticker := rf.clock.NewTicker(rf.conf.CommitInterval)
+	defer ticker.Stop()
+
 	for !rf.killed() {
 		rf.mu.Lock()
-		isLeader := rf.state == LeaderState
-		if isLeader {
-			// If there exists an N such that N > commitIndex, a majority
-			// of matchIndex[i] ≥ N, and log[N].term == currentTerm:
-			// set commitIndex = N (§5.3, §5.4).
-			for n := rf.commitIndex + 1; n < len(rf.log); n++ {
-				if rf.log[n].Term == rf.currentTerm {
-					commitedCount := 0
-					for i := range rf.peers {
-						if rf.matchIndex[i] >= n {
-							commitedCount++
-						}
-					}
-					if commitedCount > len(rf.peers)/2 {
-						rf.commitIndex = n
-						go rf.kickApplyChan(n)
-					}
+		if !rf.leaderOf(term) {
+			rf.mu.Unlock()
+			return
+		}
+		// If there exists an N such that N > commitIndex, a majority
+		// of matchIndex[i] ≥ N, and log[N].term == currentTerm:
+		// set commitIndex = N (§5.3, §5.4).
+		// The majority is of the latest configuration, in both halves
+		// during joint consensus.
+		config, _ := rf.log.lastConfig()
+		for n := rf.commitIndex + 1; n <= rf.log.lastIndex(); n++ {
+			if rf.log.term(n) == rf.currentTerm {
+				replicated := func(server int) bool {
+					return server == rf.me || rf.matchIndex[server] >= n
+				}
+				if config.isQuorum(replicated) {
+					rf.commitIndex = n
+					go rf.kickApplyChan(n)
 				}
 			}
 		}
+		rf.checkInvariants()
+		rf.advanceConfig()
 		rf.mu.Unlock()
 
-		time.Sleep(25 * time.Millisecond)
+		<-ticker.C()
 	}
 }
 
@@ -735,10 +1222,60 @@ func (rf *Raft) commitLoop() {
 // for any long-running work.
 func Make(peers []*labrpc.ClientEnd, me int,
 	persister *Persister, applyCh chan ApplyMsg) *Raft {
+	return MakeWithTransport(MakeLabrpcTransport(peers), me, persister, applyCh)
+}
+
+// Like Make, but RPCs to the other servers go through transport instead
+// of labrpc. this server is number me in the cluster.
+func MakeWithTransport(transport Transport, me int,
+	persister *Persister, applyCh chan ApplyMsg) *Raft {
+	return makeRaft(transport, me, persister, applyCh, DefaultConfig())
+}
+
+// Like MakeWithTransport, but log entries are kept durable in logStore
+// and the term and vote in stableStore, see Config.
+func MakeWithStores(transport Transport, me int, persister *Persister,
+	logStore LogStore, stableStore StableStore, applyCh chan ApplyMsg) *Raft {
+	conf := DefaultConfig()
+	conf.LogStore = logStore
+	conf.StableStore = stableStore
+	return makeRaft(transport, me, persister, applyCh, conf)
+}
+
+// Like MakeWithTransport, with the timeouts, intervals, batch limits
+// and stores taken from conf. Start from DefaultConfig() to keep the
+// default batch limits. Fails if conf doesn't make sense.
+func MakeWithConfig(transport Transport, me int, persister *Persister,
+	applyCh chan ApplyMsg, conf Config) (*Raft, error) {
+	if err := conf.validate(transport.NumPeers()); err != nil {
+		return nil, err
+	}
+	return makeRaft(transport, me, persister, applyCh, conf), nil
+}
+
+// conf must already be valid.
+func makeRaft(transport Transport, me int, persister *Persister,
+	applyCh chan ApplyMsg, conf Config) *Raft {
 	rf := &Raft{}
-	rf.peers = peers
+	rf.peers = transport
 	rf.persister = persister
+	rf.stable = conf.StableStore
 	rf.me = me
+	rf.conf = conf
+	rf.clock = conf.Clock
+	if rf.clock == nil {
+		rf.clock = realClock{}
+	}
+	source := conf.Rand
+	if source == nil {
+		source = rand.NewSource(time.Now().UnixNano())
+	}
+	rf.rand = rand.New(source)
+	// It may have answered a heartbeat just before a crash, so it honors
+	// that leader's lease as if it had just heard from it.
+	if conf.LeaseReads {
+		rf.lastHeartbeat = rf.clock.Now()
+	}
 
 	// Your initialization code here (2A, 2B, 2C).
 
@@ -748,25 +1285,38 @@ func Make(peers []*labrpc.ClientEnd, me int,
 	// Persistent State
 	rf.currentTerm = 0
 	rf.votedFor = -1
-	// Empty first log entry for indexing
-	firstEntry := LogEntry{Entry: "", Term: 0}
-	rf.log = Log{firstEntry}
+	rf.log = makeLog(makeConfiguration(transport.NumPeers(), conf.InitialMembers))
+	rf.log.store = conf.LogStore
 
 	// Volatile State
 	rf.commitIndex = 0
 	rf.lastApplied = 0
 	rf.nextIndex = []int{}
 	rf.matchIndex = []int{}
-	for range rf.peers {
-		rf.nextIndex = append(rf.nextIndex, len(rf.log))
+	rf.lastAck = []time.Time{}
+	rf.ackSent = []time.Time{}
+	for server := 0; server < rf.peers.NumPeers(); server++ {
+		rf.nextIndex = append(rf.nextIndex, rf.log.lastIndex()+1)
 		rf.matchIndex = append(rf.matchIndex, 0)
+		rf.lastAck = append(rf.lastAck, time.Time{})
+		rf.ackSent = append(rf.ackSent, time.Time{})
 	}
 
 	// Extras
 	rf.commitChan = make(chan int)
+	for server := 0; server < rf.peers.NumPeers(); server++ {
+		rf.replicateCond = append(rf.replicateCond, sync.NewCond(&rf.mu))
+		rf.replicatePending = append(rf.replicatePending, false)
+		rf.inflight = append(rf.inflight, 0)
+		rf.probing = append(rf.probing, true)
+	}
+	rf.futures = map[int]*Future{}
 
 	// initialize from state persisted before a crash
 	rf.readPersist(persister.ReadRaftState())
+	// the service restores everything through the snapshot itself
+	rf.commitIndex = rf.log.LastIncludedIndex
+	rf.lastApplied = rf.log.LastIncludedIndex
 
 	// start goroutines for raft loops
 	go rf.ticker()
@@ -1,8 +1,7 @@
 package raft
 
 import (
-	"fmt"
-	"runtime"
+	"time"
 )
 
 // Resets the vote while updating the term.
@@ -10,12 +9,28 @@ import (
 func (rf *Raft) updateTerm(newTerm int) {
 	rf.currentTerm = newTerm
 	rf.votedFor = -1
+	rf.persistTermAndVote()
 }
 
 // Used to send RPC requests to all other peers and handle replies
 // Sends out a concurrent call to all peers to do input func
+// Only peers in the latest configuration are contacted, learners
+// included.
+// Always call this while holding the raft lock.
 func (rf *Raft) sendToPeers(fn func(server int)) {
-	for server := range rf.peers {
+	config, _ := rf.log.lastConfig()
+	for server := 0; server < rf.peers.NumPeers(); server++ {
+		if server != rf.me && config.replicates(server) {
+			go fn(server)
+		}
+	}
+}
+
+// Like sendToPeers, but skips learners. Used when only votes count.
+// Always call this while holding the raft lock.
+func (rf *Raft) sendToVoters(fn func(server int)) {
+	config, _ := rf.log.lastConfig()
+	for _, server := range config.servers() {
 		if server != rf.me {
 			go fn(server)
 		}
@@ -25,17 +40,31 @@ func (rf *Raft) sendToPeers(fn func(server int)) {
 func (rf *Raft) becomeLeader() {
 	rf.state = LeaderState
 	// Reinitialize after election
-	for i := range rf.peers {
-		rf.nextIndex[i] = len(rf.log)
+	for i := 0; i < rf.peers.NumPeers(); i++ {
+		rf.nextIndex[i] = rf.log.lastIndex() + 1
 		rf.matchIndex[i] = 0
+		// Give every follower a full election timeout to answer
+		rf.lastAck[i] = rf.clock.Now()
 	}
+	// Appended after nextIndex is set, so followers get sent it.
+	rf.log.append(LogEntry{Entry: NoOp{Term: rf.currentTerm}, Term: rf.currentTerm})
+	rf.persist()
 
 	//starts  a go routine to maintain each followers log.
-	rf.sendToPeers(rf.maintainLogsLoop)
-	go rf.commitLoop()
-	go rf.heartbeatLoop()
+	// Every peer gets one, in case it joins the configuration later.
+	for server := 0; server < rf.peers.NumPeers(); server++ {
+		if server != rf.me {
+			// Until it accepts an AppendEntries, we don't know where its
+			// log matches ours.
+			rf.probing[server] = true
+			rf.replicatePending[server] = true
+			go rf.maintainLogsLoop(server, rf.currentTerm)
+		}
+	}
+	go rf.commitLoop(rf.currentTerm)
+	go rf.heartbeatLoop(rf.currentTerm)
+	rf.checkInvariants()
 	// fmt.Printf("%v Elected\n", rf.me)
-	fmt.Printf("num goroutines: %v\n", runtime.NumGoroutine())
 }
 
 func (rf *Raft) becomeCandidate() {
@@ -44,6 +73,39 @@ func (rf *Raft) becomeCandidate() {
 
 func (rf *Raft) revertToFollower() {
 	rf.state = FollowerState
+	// Whether our entries commit is up to the next leader now.
+	rf.failFutures(0, ErrLeadershipLost)
+	// Any lease we held as leader is gone.
+	for i := range rf.ackSent {
+		rf.ackSent[i] = time.Time{}
+	}
+	// Wake the replication loops so they exit.
+	for _, cond := range rf.replicateCond {
+		cond.Broadcast()
+	}
+}
+
+// Whether this server is still the leader elected in term.
+// Always call this while holding the raft lock.
+func (rf *Raft) leaderOf(term int) bool {
+	return rf.state == LeaderState && rf.currentTerm == term
+}
+
+// Wakes the leader's maintainLogsLoop for every peer to check whether
+// it needs entries.
+// Always call this while holding the raft lock.
+func (rf *Raft) triggerReplication() {
+	for server := range rf.replicateCond {
+		rf.signalReplication(server)
+	}
+}
+
+// Wakes the leader's maintainLogsLoop for one peer.
+// Always call this while holding the raft lock.
+func (rf *Raft) signalReplication(server int) {
+	rf.replicatePending[server] = true
+	// Broadcast, a loop from an earlier term may be waiting too.
+	rf.replicateCond[server].Broadcast()
 }
 
 func min(a, b int) int {
@@ -53,6 +115,13 @@ func min(a, b int) int {
 	return b
 }
 
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
 // Send in a go routine so as not to block
 func (rf *Raft) kickApplyChan(newCommit int) {
 	rf.commitChan <- newCommit
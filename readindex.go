@@ -0,0 +1,116 @@
+package raft
+
+import "context"
+
+// ReadIndex (Raft thesis §6.4): a leader can serve a read-only query
+// without appending it to the log. It notes its commitIndex, makes sure
+// it is still the leader by hearing back from a majority, and once the
+// state machine has caught up to that index any read reflects every
+// write committed before the query arrived. In lease mode (lease.go)
+// the heartbeat round is skipped while the leader's lease holds.
+
+// Returns an index the service can serve a linearizable read at. Once
+// ReadIndex returns, every entry through index has been sent on applyCh,
+// so the service answers the read after it has applied index itself.
+// Fails with ErrNotLeader or ErrLeadershipLost if this server can't
+// vouch for the read, or with ctx.Err() if ctx is done first.
+func (rf *Raft) ReadIndex(ctx context.Context) (int, error) {
+	rf.mu.Lock()
+	if rf.state != LeaderState {
+		rf.mu.Unlock()
+		return -1, ErrNotLeader
+	}
+	term := rf.currentTerm
+	rf.mu.Unlock()
+
+	// Until the leader commits an entry of its own term, it may not know
+	// everything that was committed before it was elected.
+	err := rf.waitFor(ctx, term, func() bool {
+		return rf.log.term(rf.commitIndex) == term
+	})
+	if err != nil {
+		return -1, err
+	}
+
+	// The lease must cover the moment index was read.
+	rf.mu.Lock()
+	index := rf.commitIndex
+	haveLease := rf.leaseValid()
+	rf.mu.Unlock()
+
+	// No round trip needed while the lease holds.
+	if !haveLease {
+		if err := rf.confirmLeadership(ctx, term); err != nil {
+			return -1, err
+		}
+	}
+
+	err = rf.waitFor(ctx, term, func() bool {
+		return rf.lastApplied >= index
+	})
+	if err != nil {
+		return -1, err
+	}
+	return index, nil
+}
+
+// Sends a round of heartbeats for term and waits for a quorum of the
+// latest configuration (counting ourselves) to acknowledge it.
+func (rf *Raft) confirmLeadership(ctx context.Context, term int) error {
+	type ack struct {
+		server int
+		acked  bool
+	}
+	ackChan := make(chan ack, rf.peers.NumPeers())
+	heartbeat := func(server int) {
+		ackChan <- ack{server, rf.sendHeartbeat(server, term)}
+	}
+
+	rf.mu.Lock()
+	config, _ := rf.log.lastConfig()
+	rf.sendToVoters(heartbeat)
+	rf.mu.Unlock()
+
+	acked := map[int]bool{rf.me: true}
+	replies := map[int]bool{rf.me: true}
+	for !config.isQuorum(func(s int) bool { return acked[s] }) {
+		// Give up once too many have refused for a quorum to be possible.
+		if !config.isQuorum(func(s int) bool { return !replies[s] || acked[s] }) {
+			return ErrLeadershipLost
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case a := <-ackChan:
+			replies[a.server] = true
+			acked[a.server] = a.acked
+		}
+	}
+	return nil
+}
+
+// Polls cond (called while holding the raft lock) every PollInterval
+// until it holds. Gives up if this server is no longer the leader of
+// term.
+func (rf *Raft) waitFor(ctx context.Context, term int, cond func() bool) error {
+	for !rf.killed() {
+		rf.mu.Lock()
+		stillLeader := rf.state == LeaderState && rf.currentTerm == term
+		done := cond()
+		rf.mu.Unlock()
+
+		if done {
+			return nil
+		}
+		if !stillLeader {
+			return ErrLeadershipLost
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-rf.clock.After(rf.conf.PollInterval):
+		}
+	}
+	return ErrLeadershipLost
+}
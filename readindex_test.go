@@ -0,0 +1,63 @@
+package raft
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadIndex(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	written := c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	rf := c.rafts[leader]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	index, err := rf.ReadIndex(ctx)
+	if err != nil {
+		t.Fatalf("ReadIndex on the leader failed: %v", err)
+	}
+	if index < written {
+		t.Fatalf("ReadIndex returned %v, before the write committed at %v", index, written)
+	}
+	rf.mu.Lock()
+	lastApplied := rf.lastApplied
+	rf.mu.Unlock()
+	if lastApplied < index {
+		t.Fatalf("ReadIndex returned %v with only %v applied", index, lastApplied)
+	}
+
+	follower := (leader + 1) % 3
+	if _, err := c.rafts[follower].ReadIndex(ctx); err != ErrNotLeader {
+		t.Fatalf("ReadIndex on a follower returned %v, want %v", err, ErrNotLeader)
+	}
+}
+
+// Without a lease, a leader cut off from the others can't confirm it
+// still leads, so it mustn't serve a read.
+func TestReadIndexIsolatedLeader(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	c.disconnect(leader)
+
+	// Still leader, until CheckQuorum notices, but no heartbeat gets
+	// through.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	index, err := c.rafts[leader].ReadIndex(ctx)
+	if err != ErrLeadershipLost && err != ErrNotLeader {
+		t.Fatalf("isolated leader's ReadIndex returned %v, %v", index, err)
+	}
+
+	c.one(2, 2, false)
+	c.connect(leader)
+	c.one(3, 3, true)
+}
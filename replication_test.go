@@ -0,0 +1,164 @@
+package raft
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+// Counts the AppendEntries a peer sends, with and without entries.
+type countingTransport struct {
+	Transport
+	heartbeats *int64
+	appends    *int64
+}
+
+func (t *countingTransport) AppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	if len(args.Entries) == 0 {
+		atomic.AddInt64(t.heartbeats, 1)
+	} else {
+		atomic.AddInt64(t.appends, 1)
+	}
+	return t.Transport.AppendEntries(server, args, reply)
+}
+
+// Once everything is replicated, the leader sends nothing but one
+// heartbeat per follower each HeartbeatInterval.
+func TestIdleLeaderHeartbeats(t *testing.T) {
+	var heartbeats, appends int64
+	c := makeCluster(t, 3)
+	c.makePeer = func(ends []*labrpc.ClientEnd, i int, persister *Persister, applyCh chan ApplyMsg) *Raft {
+		transport := &countingTransport{MakeLabrpcTransport(ends), &heartbeats, &appends}
+		rf, err := MakeWithConfig(transport, i, persister, applyCh, DefaultConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rf
+	}
+	c.begin()
+	defer c.cleanup()
+
+	c.one(101, 3, false)
+	time.Sleep(200 * time.Millisecond)
+	atomic.StoreInt64(&heartbeats, 0)
+	atomic.StoreInt64(&appends, 0)
+	idle := time.Second
+	time.Sleep(idle)
+
+	// Two followers, with room for a tick either side and a slow scheduler.
+	ticks := int64(idle / DefaultConfig().HeartbeatInterval)
+	if n := atomic.LoadInt64(&appends); n != 0 {
+		t.Fatalf("idle leader sent %v AppendEntries with entries", n)
+	}
+	if n := atomic.LoadInt64(&heartbeats); n < ticks || n > 2*(ticks+2) {
+		t.Fatalf("idle leader sent %v heartbeats in %v, want about %v", n, idle, 2*ticks)
+	}
+}
+
+// Start wakes the replication loops itself, so an entry reaches the
+// followers and commits long before the next heartbeat.
+func TestStartReplicatesImmediately(t *testing.T) {
+	conf := DefaultConfig()
+	conf.HeartbeatInterval = time.Second
+	conf.MinElectionTimeout = 3 * time.Second
+	conf.MaxElectionTimeout = 4 * time.Second
+	for seed := int64(1); seed <= 5; seed++ {
+		sim, err := NewSimulation(SimOptions{Peers: 3, Seed: seed, Config: conf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		leader := -1
+		if !sim.RunUntil(func() bool {
+			var ok bool
+			leader, ok = sim.Leader()
+			return ok
+		}, time.Minute) {
+			sim.Close()
+			t.Fatalf("seed %v: no leader", seed)
+		}
+		// Somewhere between two heartbeats.
+		sim.RunFor(time.Duration(seed) * 150 * time.Millisecond)
+
+		index, _, _ := sim.Start(leader, 101)
+		sim.RunFor(100 * time.Millisecond)
+		for i := 0; i < 3; i++ {
+			rf := sim.Peer(i)
+			rf.mu.Lock()
+			last, commitIndex := rf.log.lastIndex(), rf.commitIndex
+			rf.mu.Unlock()
+			if last < index {
+				sim.Close()
+				t.Fatalf("seed %v: peer %v has no entry %v 100ms after Start", seed, i, index)
+			}
+			if i == leader && commitIndex < index {
+				sim.Close()
+				t.Fatalf("seed %v: leader hasn't committed %v 100ms after Start", seed, index)
+			}
+		}
+		sim.Close()
+	}
+}
+
+// Compares waiting for each AppendEntries reply against keeping a few in
+// flight, on a simulated network where a round trip takes 20ms. The
+// delay is fixed so requests arrive in order; with jitter, pipelined
+// requests overtake each other and the follower sends the leader back
+// to probing. The number that matters is entries/sim-s, committed
+// entries per second of simulated time; ns/op is mostly the
+// simulation's own overhead.
+func BenchmarkInflightAppends(b *testing.B) {
+	const entries = 200
+	for _, window := range []int{1, 4} {
+		b.Run(fmt.Sprintf("window=%v", window), func(b *testing.B) {
+			var simulated time.Duration
+			for n := 0; n < b.N; n++ {
+				conf := DefaultConfig()
+				// Small batches, so a follower that has to catch up
+				// needs many requests.
+				conf.MaxAppendEntries = 8
+				conf.MaxInflightAppends = window
+				sim, err := NewSimulation(SimOptions{
+					Peers:    3,
+					Seed:     int64(n),
+					MinDelay: 10 * time.Millisecond,
+					MaxDelay: 10 * time.Millisecond,
+					Config:   conf,
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				leader := -1
+				if !sim.RunUntil(func() bool {
+					var ok bool
+					leader, ok = sim.Leader()
+					return ok
+				}, 10*time.Second) {
+					b.Fatalf("no leader")
+				}
+
+				start := sim.Now()
+				last := -1
+				for i := 0; i < entries; i++ {
+					last, _, _ = sim.Start(leader, i)
+				}
+				// Committed as far as a client of the leader can tell.
+				committed := func() bool {
+					rf := sim.Peer(leader)
+					rf.mu.Lock()
+					defer rf.mu.Unlock()
+					return rf.commitIndex >= last
+				}
+				if !sim.RunUntil(committed, time.Minute) {
+					b.Fatalf("entries not committed: %v", sim.Err())
+				}
+				simulated += sim.Now() - start
+				sim.Close()
+			}
+			b.ReportMetric(float64(entries*b.N)/simulated.Seconds(), "entries/sim-s")
+		})
+	}
+}
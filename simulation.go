@@ -0,0 +1,656 @@
+package raft
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"6.824/labgob"
+)
+
+// Simulation runs a cluster of Raft peers against a simulated network
+// and a FakeClock, all driven by one event loop and one seed, so a run
+// that breaks can be replayed exactly. The caller scripts it from a
+// single goroutine:
+//
+//	sim, _ := NewSimulation(SimOptions{Peers: 5, Seed: seed})
+//	defer sim.Close()
+//	sim.RunFor(time.Second)
+//	leader, _ := sim.Leader()
+//	sim.Partition([]int{leader}, others)
+//	sim.Start(leader, "x")
+//	sim.RunFor(time.Second)
+//	if err := sim.Err(); err != nil { ... }
+//
+// Each step lets the peers' goroutines run until they are all waiting
+// on the clock or the network, then moves time to the next timer or
+// message and fires it. Requests sent during a step are put in a fixed
+// order before the seed picks their delays and losses, so the outcome
+// doesn't depend on which goroutine sent first. Election Safety and Log
+// Matching are checked after every step.
+//
+// Peers keep their own goroutines, but none of them moves unless the
+// loop fires one of its timers or delivers one of its messages. A step
+// only goes on once the scheduler reports every other goroutine blocked,
+// so a replay is exact whatever GOMAXPROCS is. Peers must block on
+// nothing but the clock, the network, locks and channels: one sleeping
+// on the real clock looks settled when it isn't.
+
+// Defaults for SimOptions fields left at zero.
+const (
+	defaultSimMinDelay     = 1 * time.Millisecond
+	defaultSimMaxDelay     = 10 * time.Millisecond
+	defaultSimReorderDelay = 50 * time.Millisecond
+)
+
+type SimOptions struct {
+	Peers int
+	Seed  int64
+	// Bounds of the one-way delay of each message.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// Fraction of requests and replies lost on the way.
+	DropRate float64
+	// Fraction of messages held back up to ReorderDelay more, so later
+	// ones overtake them.
+	ReorderRate  float64
+	ReorderDelay time.Duration
+	// Every peer is made with this, except for Clock and Rand, which the
+	// simulation provides.
+	Config Config
+	// Where scripted actions and invariant violations are logged, if set.
+	Logf func(format string, args ...interface{})
+}
+
+type Simulation struct {
+	opts  SimOptions
+	clock *FakeClock
+	start time.Time
+	// Picks delays and losses, only used by the event loop
+	rand *rand.Rand
+
+	rafts      []*Raft
+	persisters []*Persister
+	crashed    []bool
+	// Closed on crash, to stop reading each peer's applyCh
+	stopApply []chan bool
+	// Bumped on each restart, messages from an earlier run are dropped
+	incarnation []int
+	// Peers only reach others in the same group
+	group []int
+
+	// Requests sent since the last step, and how many ever
+	mu       sync.Mutex
+	newCalls []*simCall
+	sent     int
+	closed   bool
+
+	events simEventQueue
+	seq    int
+	// For goroutine dumps in settle
+	stacks []byte
+
+	// Leader seen in each term
+	leaders map[int]int
+	steps   int
+	err     error
+}
+
+type simCall struct {
+	from        int
+	to          int
+	incarnation int
+	method      string
+	args        interface{}
+	// args encoded, to put requests in a fixed order
+	key   []byte
+	reply interface{}
+	lost  bool
+	done  chan bool
+}
+
+type simEvent struct {
+	at   time.Time
+	seq  int
+	call *simCall
+	// Delivering the reply rather than the request
+	isReply bool
+	ok      bool
+}
+
+type simEventQueue []*simEvent
+
+func (q simEventQueue) Len() int { return len(q) }
+func (q simEventQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q simEventQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *simEventQueue) Push(x interface{}) { *q = append(*q, x.(*simEvent)) }
+func (q *simEventQueue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+// Starts a cluster of opts.Peers peers, all connected.
+func NewSimulation(opts SimOptions) (*Simulation, error) {
+	if opts.Peers <= 0 {
+		return nil, fmt.Errorf("raft: sim: need at least one peer")
+	}
+	if opts.MinDelay == 0 {
+		opts.MinDelay = defaultSimMinDelay
+	}
+	if opts.MaxDelay == 0 {
+		opts.MaxDelay = defaultSimMaxDelay
+	}
+	if opts.ReorderDelay == 0 {
+		opts.ReorderDelay = defaultSimReorderDelay
+	}
+	if opts.MaxDelay < opts.MinDelay {
+		return nil, fmt.Errorf("raft: sim: MaxDelay %v below MinDelay %v", opts.MaxDelay, opts.MinDelay)
+	}
+	if err := opts.Config.validate(opts.Peers); err != nil {
+		return nil, err
+	}
+
+	start := time.Unix(0, 0)
+	s := &Simulation{
+		opts:        opts,
+		clock:       NewFakeClock(start),
+		start:       start,
+		rand:        rand.New(rand.NewSource(opts.Seed)),
+		rafts:       make([]*Raft, opts.Peers),
+		persisters:  make([]*Persister, opts.Peers),
+		crashed:     make([]bool, opts.Peers),
+		stopApply:   make([]chan bool, opts.Peers),
+		incarnation: make([]int, opts.Peers),
+		group:       make([]int, opts.Peers),
+		leaders:     map[int]int{},
+	}
+	for i := 0; i < opts.Peers; i++ {
+		s.persisters[i] = MakePersister()
+		s.boot(i)
+	}
+	return s, nil
+}
+
+// Makes a Raft for peer i on its persister.
+func (s *Simulation) boot(i int) {
+	conf := s.opts.Config
+	conf.Clock = s.clock
+	// Same seed for the same peer and run, whatever happened before.
+	conf.Rand = rand.NewSource(s.opts.Seed*1000003 + int64(i)*1009 + int64(s.incarnation[i]))
+
+	applyCh := make(chan ApplyMsg)
+	stop := make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-applyCh:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	s.stopApply[i] = stop
+	transport := &simTransport{sim: s, me: i, incarnation: s.incarnation[i]}
+	s.rafts[i] = makeRaft(transport, i, s.persisters[i], applyCh, conf)
+	s.crashed[i] = false
+}
+
+func (s *Simulation) logf(format string, args ...interface{}) {
+	if s.opts.Logf != nil {
+		s.opts.Logf("sim %v: "+format, append([]interface{}{s.Now()}, args...)...)
+	}
+}
+
+// Simulated time since the start.
+func (s *Simulation) Now() time.Duration {
+	return s.clock.Now().Sub(s.start)
+}
+
+// Peer i, or nil while it is crashed.
+func (s *Simulation) Peer(i int) *Raft {
+	if s.crashed[i] {
+		return nil
+	}
+	return s.rafts[i]
+}
+
+// The peer that thinks it leads the highest term, among those running.
+func (s *Simulation) Leader() (int, bool) {
+	leader := -1
+	leaderTerm := -1
+	for i, rf := range s.rafts {
+		if s.crashed[i] {
+			continue
+		}
+		if term, isLeader := rf.GetState(); isLeader && term > leaderTerm {
+			leader = i
+			leaderTerm = term
+		}
+	}
+	return leader, leader != -1
+}
+
+// Hands command to peer i, as a client would, and lets the peer act on
+// it before the script goes on.
+func (s *Simulation) Start(i int, command interface{}) (int, int, bool) {
+	if s.crashed[i] {
+		return -1, -1, false
+	}
+	index, term, isLeader := s.rafts[i].Start(command)
+	s.settle()
+	return index, term, isLeader
+}
+
+// Splits the network so peers only reach the others in their group.
+// Peers in no group are cut off from everyone.
+func (s *Simulation) Partition(groups ...[]int) {
+	for i := range s.group {
+		s.group[i] = -1 - i
+	}
+	for g, members := range groups {
+		for _, i := range members {
+			s.group[i] = g
+		}
+	}
+	s.logf("partition %v", groups)
+}
+
+// Reconnects every peer to every other.
+func (s *Simulation) Heal() {
+	for i := range s.group {
+		s.group[i] = 0
+	}
+	s.logf("heal")
+}
+
+func (s *Simulation) connected(a, b int) bool {
+	return s.group[a] == s.group[b]
+}
+
+// Stops peer i. What it persisted survives for Restart.
+func (s *Simulation) Crash(i int) {
+	if s.crashed[i] {
+		return
+	}
+	s.rafts[i].Kill()
+	// Once applyChRoutine has seen it and returned.
+	s.settle()
+	close(s.stopApply[i])
+	s.crashed[i] = true
+	// Writes from goroutines still winding down don't reach the copy.
+	s.persisters[i] = s.persisters[i].Copy()
+	s.logf("crash %v", i)
+}
+
+// Starts peer i again from what it persisted, crashing it first if it
+// is running.
+func (s *Simulation) Restart(i int) {
+	s.Crash(i)
+	s.incarnation[i]++
+	s.boot(i)
+	s.settle()
+	s.logf("restart %v", i)
+}
+
+// The first invariant violation seen, if any.
+func (s *Simulation) Err() error {
+	return s.err
+}
+
+// Runs for d of simulated time, or until an invariant is violated.
+func (s *Simulation) RunFor(d time.Duration) error {
+	end := s.clock.Now().Add(d)
+	for s.err == nil && s.clock.Now().Before(end) {
+		s.step(end)
+	}
+	return s.err
+}
+
+// Runs until done returns true, checked after every step, for at most
+// limit of simulated time. Returns whether done came true.
+func (s *Simulation) RunUntil(done func() bool, limit time.Duration) bool {
+	end := s.clock.Now().Add(limit)
+	for s.err == nil && !done() {
+		if !s.clock.Now().Before(end) {
+			return false
+		}
+		s.step(end)
+	}
+	return s.err == nil
+}
+
+// Stops every peer and fails whatever is still in flight.
+func (s *Simulation) Close() {
+	for i := range s.rafts {
+		s.Crash(i)
+	}
+	s.mu.Lock()
+	s.closed = true
+	calls := s.newCalls
+	s.newCalls = nil
+	s.mu.Unlock()
+
+	for _, call := range calls {
+		call.done <- false
+	}
+	// Each call has exactly one event waiting, its request or its reply.
+	for _, e := range s.events {
+		e.call.done <- false
+	}
+	s.events = nil
+	// Wake the sleeping loops so they notice they were killed.
+	s.clock.Advance(time.Minute)
+}
+
+// Lets the peers settle, moves time to the next timer or message no
+// later than limit, fires everything due then and checks the
+// invariants. Requests the peers send in response are delivered in a
+// later step.
+func (s *Simulation) step(limit time.Time) {
+	s.settle()
+
+	next := limit
+	if len(s.events) > 0 && s.events[0].at.Before(next) {
+		next = s.events[0].at
+	}
+	if at, ok := s.clock.next(); ok && at.Before(next) {
+		next = at
+	}
+	if next.Before(s.clock.Now()) {
+		next = s.clock.Now()
+	}
+	// One timer or message at a time, settling in between, so peers
+	// woken at the same instant don't race each other.
+	for s.clock.fireNext(next) {
+		s.settle()
+	}
+	s.clock.Advance(next.Sub(s.clock.Now()))
+	for len(s.events) > 0 && !s.events[0].at.After(next) {
+		s.deliver(heap.Pop(&s.events).(*simEvent))
+		s.settle()
+	}
+
+	s.steps++
+	s.check()
+}
+
+// Waits for the peers' goroutines to block, then schedules the
+// requests they sent in a fixed order.
+func (s *Simulation) settle() {
+	for {
+		runtime.Gosched()
+		if s.quiet() {
+			break
+		}
+	}
+
+	s.mu.Lock()
+	calls := s.newCalls
+	s.newCalls = nil
+	s.mu.Unlock()
+
+	sort.Slice(calls, func(i, j int) bool {
+		a, b := calls[i], calls[j]
+		if a.from != b.from {
+			return a.from < b.from
+		}
+		if a.to != b.to {
+			return a.to < b.to
+		}
+		if a.method != b.method {
+			return a.method < b.method
+		}
+		return bytes.Compare(a.key, b.key) < 0
+	})
+	for _, call := range calls {
+		call.lost = s.rand.Float64() < s.opts.DropRate
+		s.schedule(call, false, false)
+	}
+}
+
+// Whether every goroutine but this one is blocked. Goroutines woken by
+// a timer or a message are runnable until they block again, so none of
+// them can be missed, whichever thread they run on.
+func (s *Simulation) quiet() bool {
+	if s.stacks == nil {
+		s.stacks = make([]byte, 64<<10)
+	}
+	n := runtime.Stack(s.stacks, true)
+	for n == len(s.stacks) {
+		s.stacks = make([]byte, 2*len(s.stacks))
+		n = runtime.Stack(s.stacks, true)
+	}
+
+	// Each goroutine starts "goroutine 7 [chan receive, 2 minutes]:",
+	// this one first.
+	dump := s.stacks[:n]
+	for first := true; len(dump) > 0; first = false {
+		var header []byte
+		if end := bytes.IndexByte(dump, '\n'); end >= 0 {
+			header, dump = dump[:end], dump[end+1:]
+		} else {
+			header, dump = dump, nil
+		}
+		if end := bytes.Index(dump, []byte("\n\n")); end >= 0 {
+			dump = dump[end+2:]
+		} else {
+			dump = nil
+		}
+		if first {
+			continue
+		}
+
+		open, close := bytes.IndexByte(header, '['), bytes.IndexByte(header, ']')
+		if open < 0 || close < open {
+			continue
+		}
+		status := string(header[open+1 : close])
+		if comma := strings.IndexByte(status, ','); comma >= 0 {
+			status = status[:comma]
+		}
+		switch {
+		case status == "runnable", status == "running", status == "syscall",
+			status == "preempted", status == "copystack", strings.HasPrefix(status, "GC"):
+			return false
+		}
+	}
+	return true
+}
+
+func (s *Simulation) schedule(call *simCall, isReply bool, ok bool) {
+	delay := s.opts.MinDelay
+	if s.opts.MaxDelay > s.opts.MinDelay {
+		delay += time.Duration(s.rand.Int63n(int64(s.opts.MaxDelay - s.opts.MinDelay)))
+	}
+	if s.rand.Float64() < s.opts.ReorderRate {
+		delay += time.Duration(s.rand.Int63n(int64(s.opts.ReorderDelay)))
+	}
+	s.seq++
+	heap.Push(&s.events, &simEvent{
+		at:      s.clock.Now().Add(delay),
+		seq:     s.seq,
+		call:    call,
+		isReply: isReply,
+		ok:      ok,
+	})
+}
+
+func (s *Simulation) deliver(e *simEvent) {
+	call := e.call
+	// A crashed sender is gone, and a restarted one is someone new.
+	senderGone := s.crashed[call.from] || s.incarnation[call.from] != call.incarnation
+	if e.isReply {
+		call.done <- e.ok && !senderGone && s.connected(call.from, call.to)
+		return
+	}
+
+	if call.lost || senderGone || s.crashed[call.to] || !s.connected(call.from, call.to) {
+		// The sender hears nothing back.
+		s.schedule(call, true, false)
+		return
+	}
+	s.handle(s.rafts[call.to], call)
+	replyLost := s.rand.Float64() < s.opts.DropRate
+	s.schedule(call, true, !replyLost)
+}
+
+// Runs the RPC handler on rf, right here in the event loop.
+func (s *Simulation) handle(rf *Raft, call *simCall) {
+	switch args := call.args.(type) {
+	case *RequestVoteArgs:
+		if call.method == "PreVote" {
+			rf.PreVote(args, call.reply.(*RequestVoteReply))
+		} else {
+			rf.RequestVote(args, call.reply.(*RequestVoteReply))
+		}
+	case *AppendEntriesArgs:
+		rf.AppendEntries(args, call.reply.(*AppendEntriesReply))
+	case *InstallSnapshotArgs:
+		rf.InstallSnapshot(args, call.reply.(*InstallSnapshotReply))
+	case *TimeoutNowArgs:
+		rf.TimeoutNow(args, call.reply.(*TimeoutNowReply))
+	}
+}
+
+// Sends a request and blocks until the event loop delivers the reply,
+// or the loss of the request or the reply.
+func (s *Simulation) call(from int, incarnation int, to int, method string, args interface{}, reply interface{}) bool {
+	key, argsCopy := simCopy(args)
+	call := &simCall{
+		from:        from,
+		to:          to,
+		incarnation: incarnation,
+		method:      method,
+		args:        argsCopy,
+		key:         key,
+		reply:       reflect.New(reflect.TypeOf(reply).Elem()).Interface(),
+		done:        make(chan bool, 1),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	s.newCalls = append(s.newCalls, call)
+	s.sent++
+	s.mu.Unlock()
+
+	if !<-call.done {
+		return false
+	}
+	_, replyCopy := simCopy(call.reply)
+	reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(replyCopy).Elem())
+	return true
+}
+
+// Encodes v, a pointer, and decodes it into a fresh copy, as a real
+// network would.
+func simCopy(v interface{}) ([]byte, interface{}) {
+	w := new(bytes.Buffer)
+	if err := labgob.NewEncoder(w).Encode(v); err != nil {
+		panic(fmt.Sprintf("raft: sim: encoding %T: %v", v, err))
+	}
+	encoded := w.Bytes()
+	fresh := reflect.New(reflect.TypeOf(v).Elem()).Interface()
+	if err := labgob.NewDecoder(bytes.NewBuffer(encoded)).Decode(fresh); err != nil {
+		panic(fmt.Sprintf("raft: sim: decoding %T: %v", v, err))
+	}
+	return encoded, fresh
+}
+
+// Checks Election Safety (at most one leader per term, over the whole
+// run) and Log Matching (logs that hold an entry with the same index and
+// term are identical up to it).
+func (s *Simulation) check() {
+	if s.err != nil {
+		return
+	}
+
+	states := map[int]peerState{}
+	for i, rf := range s.rafts {
+		if s.crashed[i] {
+			continue
+		}
+		rf.mu.Lock()
+		states[i] = rf.copyState()
+		rf.mu.Unlock()
+	}
+
+	for i := range s.rafts {
+		state, ok := states[i]
+		if !ok || state.state != LeaderState {
+			continue
+		}
+		if other, ok := s.leaders[state.term]; ok && other != i {
+			s.fail("election safety: %v and %v both led term %v", other, i, state.term)
+			return
+		}
+		s.leaders[state.term] = i
+	}
+
+	for i := range s.rafts {
+		for j := i + 1; j < len(s.rafts); j++ {
+			a, aok := states[i]
+			b, bok := states[j]
+			if !aok || !bok {
+				continue
+			}
+			if index, ok := logsMatch(a, b); !ok {
+				s.fail("log matching: %v and %v share an entry but differ at index %v", i, j, index)
+				return
+			}
+		}
+	}
+}
+
+func (s *Simulation) fail(format string, args ...interface{}) {
+	s.err = fmt.Errorf("raft: sim: seed %v, step %v, at %v: %v",
+		s.opts.Seed, s.steps, s.Now(), fmt.Sprintf(format, args...))
+	s.logf("%v", s.err)
+}
+
+// Transport for one peer of a Simulation.
+type simTransport struct {
+	sim         *Simulation
+	me          int
+	incarnation int
+}
+
+func (t *simTransport) NumPeers() int {
+	return t.sim.opts.Peers
+}
+
+func (t *simTransport) RequestVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	return t.sim.call(t.me, t.incarnation, server, "RequestVote", args, reply)
+}
+
+func (t *simTransport) PreVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	return t.sim.call(t.me, t.incarnation, server, "PreVote", args, reply)
+}
+
+func (t *simTransport) AppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	return t.sim.call(t.me, t.incarnation, server, "AppendEntries", args, reply)
+}
+
+func (t *simTransport) InstallSnapshot(server int, args *InstallSnapshotArgs, reply *InstallSnapshotReply) bool {
+	return t.sim.call(t.me, t.incarnation, server, "InstallSnapshot", args, reply)
+}
+
+func (t *simTransport) TimeoutNow(server int, args *TimeoutNowArgs, reply *TimeoutNowReply) bool {
+	return t.sim.call(t.me, t.incarnation, server, "TimeoutNow", args, reply)
+}
@@ -0,0 +1,179 @@
+package raft
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Runs a scripted scenario and records, after every step, the time, the
+// requests sent so far, the timers waiting and each peer's term, role,
+// log and commit index.
+func simTrace(t *testing.T, seed int64) []string {
+	sim, err := NewSimulation(SimOptions{
+		Peers:       5,
+		Seed:        seed,
+		DropRate:    0.1,
+		ReorderRate: 0.1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	trace := []string{}
+	record := func(what string) {
+		sim.mu.Lock()
+		line := fmt.Sprintf("%v %v sent %v timers %v:", sim.Now(), what, sim.sent, sim.clock.Waiters())
+		sim.mu.Unlock()
+		for i := 0; i < 5; i++ {
+			rf := sim.Peer(i)
+			if rf == nil {
+				line += " down"
+				continue
+			}
+			rf.mu.Lock()
+			line += fmt.Sprintf(" %v/%v/%v/%v", rf.currentTerm, rf.state, rf.log.lastIndex(), rf.commitIndex)
+			rf.mu.Unlock()
+		}
+		trace = append(trace, line)
+	}
+	run := func(d time.Duration) {
+		end := sim.clock.Now().Add(d)
+		for sim.Err() == nil && sim.clock.Now().Before(end) {
+			sim.step(end)
+			record("step")
+		}
+		if err := sim.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run(time.Second)
+	for round := 0; round < 3; round++ {
+		leader, ok := sim.Leader()
+		if ok {
+			for i := 0; i < 5; i++ {
+				sim.Start(leader, round*10+i)
+			}
+			record(fmt.Sprintf("start on %v", leader))
+		}
+		run(300 * time.Millisecond)
+		if ok {
+			sim.Partition([]int{leader, (leader + 1) % 5}, []int{(leader + 2) % 5, (leader + 3) % 5, (leader + 4) % 5})
+			record("partition")
+			run(time.Second)
+			sim.Heal()
+			sim.Restart(leader)
+			record("restart")
+		}
+		run(500 * time.Millisecond)
+	}
+	return trace
+}
+
+// The same seed must give the same run, step for step, however many
+// threads the peers' goroutines are spread over.
+func TestSimulationReplay(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	for seed := int64(1); seed <= 3; seed++ {
+		runtime.GOMAXPROCS(1)
+		first := simTrace(t, seed)
+		runtime.GOMAXPROCS(4)
+		second := simTrace(t, seed)
+		for i := 0; i < len(first) && i < len(second); i++ {
+			if first[i] != second[i] {
+				t.Fatalf("seed %v diverged at step %v:\n%v\n%v", seed, i, first[i], second[i])
+			}
+		}
+		if len(first) != len(second) {
+			t.Fatalf("seed %v took %v steps, then %v", seed, len(first), len(second))
+		}
+	}
+}
+
+// A peer cut off from the others claims the term the majority elected a
+// leader in, as a peer that counted votes wrong would.
+func TestSimulationElectionSafetyViolation(t *testing.T) {
+	sim, err := NewSimulation(SimOptions{Peers: 5, Seed: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	if err := sim.RunFor(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	leader, ok := sim.Leader()
+	if !ok {
+		t.Fatalf("no leader")
+	}
+	isolated := (leader + 1) % 5
+	others := []int{}
+	for i := 0; i < 5; i++ {
+		if i != isolated {
+			others = append(others, i)
+		}
+	}
+	sim.Partition(others, []int{isolated})
+	if err := sim.RunFor(500 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	term, _ := sim.Peer(leader).GetState()
+	rf := sim.Peer(isolated)
+	rf.mu.Lock()
+	rf.currentTerm = term
+	rf.state = LeaderState
+	rf.mu.Unlock()
+
+	err = sim.RunFor(100 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "election safety") {
+		t.Fatalf("two leaders in term %v gave %v", term, err)
+	}
+	if sim.Err() != err {
+		t.Fatalf("Err() is %v, RunFor returned %v", sim.Err(), err)
+	}
+}
+
+// A follower restarts with an entry that differs from the leader's at
+// the same index and term, as if its disk had been written wrong.
+func TestSimulationLogMatchingViolation(t *testing.T) {
+	sim, err := NewSimulation(SimOptions{Peers: 3, Seed: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sim.Close()
+
+	if err := sim.RunFor(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	leader, ok := sim.Leader()
+	if !ok {
+		t.Fatalf("no leader")
+	}
+	index, _, _ := sim.Start(leader, "x")
+	if err := sim.RunFor(300 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	follower := (leader + 1) % 3
+	sim.Crash(follower)
+	sim.Restart(follower)
+	rf := sim.Peer(follower)
+	rf.mu.Lock()
+	if rf.log.lastIndex() < index {
+		rf.mu.Unlock()
+		t.Fatalf("follower restarted without index %v", index)
+	}
+	rf.log.Entries[index-rf.log.LastIncludedIndex].Entry = "y"
+	rf.mu.Unlock()
+
+	err = sim.RunFor(100 * time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "log matching") {
+		t.Fatalf("diverging entry at index %v gave %v", index, err)
+	}
+}
@@ -0,0 +1,143 @@
+package raft
+
+import (
+	"testing"
+)
+
+const testSnapshotInterval = 10
+
+func makeSnapshotCluster(t *testing.T, n int) *cluster {
+	c := makeCluster(t, n)
+	c.snapshotInterval = testSnapshotInterval
+	c.begin()
+	return c
+}
+
+// Checks every peer has applied, or restored from a snapshot, every
+// command committed through index.
+func checkAllApplied(t *testing.T, c *cluster, index int) {
+	t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for at, command := range c.committed {
+		if at > index {
+			continue
+		}
+		for i := 0; i < c.n; i++ {
+			if c.applied[i][at] != command {
+				t.Fatalf("peer %v has %v at index %v, want %v", i, c.applied[i][at], at, command)
+			}
+		}
+	}
+}
+
+func TestSnapshotBasic(t *testing.T) {
+	c := makeSnapshotCluster(t, 3)
+	defer c.cleanup()
+
+	var index int
+	for command := 1; command <= 50; command++ {
+		index = c.one(command, 3, false)
+	}
+	checkAllApplied(t, c, index)
+
+	for i := 0; i < c.n; i++ {
+		rf := c.rafts[i]
+		rf.mu.Lock()
+		snapshotted, kept := rf.log.LastIncludedIndex, len(rf.log.Entries)
+		rf.mu.Unlock()
+		if snapshotted == 0 || c.persisters[i].SnapshotSize() == 0 {
+			t.Fatalf("peer %v never snapshotted", i)
+		}
+		// Whatever came after the last snapshot, and the placeholder.
+		if kept > 2*testSnapshotInterval {
+			t.Fatalf("peer %v kept %v entries with a snapshot every %v", i, kept, testSnapshotInterval)
+		}
+	}
+}
+
+// A follower that misses entries the leader has since compacted away
+// can only catch up by installing the leader's snapshot.
+func TestSnapshotInstall(t *testing.T) {
+	c := makeSnapshotCluster(t, 3)
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	follower := (leader + 1) % 3
+	c.disconnect(follower)
+	rf := c.rafts[follower]
+	rf.mu.Lock()
+	followerLast := rf.log.lastIndex()
+	rf.mu.Unlock()
+
+	for command := 2; command <= 4*testSnapshotInterval; command++ {
+		c.one(command, 2, false)
+	}
+	leaderRf := c.rafts[leader]
+	leaderRf.mu.Lock()
+	compacted := leaderRf.log.LastIncludedIndex
+	leaderRf.mu.Unlock()
+	if compacted <= followerLast {
+		t.Fatalf("leader only compacted through %v, the follower has up to %v", compacted, followerLast)
+	}
+
+	c.connect(follower)
+	index := c.one(1000, 3, true)
+	checkAllApplied(t, c, index)
+	c.mu.Lock()
+	installed := c.installed[follower]
+	c.mu.Unlock()
+	if installed == 0 {
+		t.Fatalf("follower caught up without installing a snapshot")
+	}
+}
+
+// Peers restart from their snapshots, one at a time while the others
+// keep committing, then all at once.
+func TestSnapshotCrashRestart(t *testing.T) {
+	c := makeSnapshotCluster(t, 3)
+	defer c.cleanup()
+
+	command := 0
+	for round := 0; round < 5; round++ {
+		victim := round % 3
+		c.crash(victim)
+		for i := 0; i < testSnapshotInterval+2; i++ {
+			command++
+			c.one(command, 2, true)
+		}
+		c.start(victim)
+		c.connect(victim)
+		command++
+		index := c.one(command, 3, true)
+		checkAllApplied(t, c, index)
+	}
+
+	for i := 0; i < c.n; i++ {
+		c.crash(i)
+	}
+	for i := 0; i < c.n; i++ {
+		c.start(i)
+		rf := c.rafts[i]
+		rf.mu.Lock()
+		snapshotted, lastApplied := rf.log.LastIncludedIndex, rf.lastApplied
+		rf.mu.Unlock()
+		if snapshotted == 0 || lastApplied != snapshotted {
+			t.Fatalf("peer %v restarted with snapshot through %v and lastApplied %v", i, snapshotted, lastApplied)
+		}
+		// Commands in the snapshot are back before anything is applied.
+		c.mu.Lock()
+		restored := len(c.applied[i])
+		c.mu.Unlock()
+		if restored == 0 {
+			t.Fatalf("peer %v restored nothing from its snapshot", i)
+		}
+	}
+	for i := 0; i < c.n; i++ {
+		c.connect(i)
+	}
+	command++
+	index := c.one(command, 3, true)
+	checkAllApplied(t, c, index)
+}
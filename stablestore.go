@@ -0,0 +1,89 @@
+package raft
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	"6.824/labgob"
+)
+
+// StableStore keeps currentTerm and votedFor. They change rarely, but
+// must be durable before Raft replies to the RPC that changed them.
+// Keeping them apart from the log means a vote doesn't rewrite the log,
+// and appending to the log doesn't rewrite the vote.
+type StableStore interface {
+	// Replaces the saved term and vote in one atomic step.
+	SetTermAndVote(term int, votedFor int) error
+	// The saved term and vote, or 0 and -1 if nothing was saved yet.
+	TermAndVote() (term int, votedFor int, err error)
+}
+
+// StableStore in a single file. Each save writes a temporary file,
+// fsyncs it and renames it over the old one, so a crash leaves either
+// the old term and vote or the new ones, never a mix.
+type FileStableStore struct {
+	path string
+}
+
+// Opens the store kept at path, creating its directory if needed.
+func OpenFileStableStore(path string) (*FileStableStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Left over from a save that crashed before the rename.
+	if err := os.Remove(path + ".tmp"); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return &FileStableStore{path: path}, nil
+}
+
+func (s *FileStableStore) SetTermAndVote(term int, votedFor int) error {
+	w := new(bytes.Buffer)
+	e := labgob.NewEncoder(w)
+	e.Encode(term)
+	e.Encode(votedFor)
+
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(w.Bytes()); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	// Make the rename itself durable.
+	return syncDir(filepath.Dir(s.path))
+}
+
+func (s *FileStableStore) TermAndVote() (int, int, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return 0, -1, nil
+	}
+	if err != nil {
+		return 0, -1, err
+	}
+
+	d := labgob.NewDecoder(bytes.NewBuffer(data))
+	var term int
+	var votedFor int
+	if err := d.Decode(&term); err != nil {
+		return 0, -1, err
+	}
+	if err := d.Decode(&votedFor); err != nil {
+		return 0, -1, err
+	}
+	return term, votedFor, nil
+}
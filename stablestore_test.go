@@ -0,0 +1,77 @@
+package raft
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"6.824/labrpc"
+)
+
+// A StableStore in memory that counts its saves.
+type countingStableStore struct {
+	mu       sync.Mutex
+	term     int
+	votedFor int
+	saves    int
+}
+
+func (s *countingStableStore) SetTermAndVote(term int, votedFor int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.term, s.votedFor = term, votedFor
+	s.saves++
+	return nil
+}
+
+func (s *countingStableStore) TermAndVote() (int, int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.term, s.votedFor, nil
+}
+
+func (s *countingStableStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saves
+}
+
+func TestRequestVoteSavesOnlyChanges(t *testing.T) {
+	stable := &countingStableStore{votedFor: -1}
+	net := labrpc.MakeNetwork()
+	ends := make([]*labrpc.ClientEnd, 3)
+	for i := range ends {
+		ends[i] = net.MakeEnd(fmt.Sprint(i))
+	}
+	rf := MakeWithStores(MakeLabrpcTransport(ends), 0, MakePersister(), nil, stable, make(chan ApplyMsg, 10))
+	defer rf.Kill()
+
+	vote := func(term int, candidate int) bool {
+		reply := &RequestVoteReply{}
+		rf.RequestVote(&RequestVoteArgs{Term: term, CandidateId: candidate}, reply)
+		return reply.VoteGranted
+	}
+	check := func(what string, granted, wantGranted bool, before, wantSaves int) {
+		t.Helper()
+		if granted != wantGranted {
+			t.Fatalf("%v: vote granted %v, want %v", what, granted, wantGranted)
+		}
+		if saves := stable.count() - before; saves != wantSaves {
+			t.Fatalf("%v: saved %v times, want %v", what, saves, wantSaves)
+		}
+	}
+
+	// The peer's own election timer is far off, nothing else saves.
+	before := stable.count()
+	check("new term", vote(100, 1), true, before, 1)
+	before = stable.count()
+	check("same candidate again", vote(100, 1), true, before, 0)
+	before = stable.count()
+	check("other candidate", vote(100, 2), false, before, 0)
+	before = stable.count()
+	check("stale term", vote(99, 2), false, before, 0)
+
+	if term, votedFor, _ := stable.TermAndVote(); term != 100 || votedFor != 1 {
+		t.Fatalf("saved term %v vote %v, want 100 and 1", term, votedFor)
+	}
+}
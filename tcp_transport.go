@@ -0,0 +1,331 @@
+package raft
+
+import (
+	"errors"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Transport over TCP using net/rpc, for running peers as separate
+// processes. Each transport listens for requests to its own Raft and
+// keeps a small pool of connections to every other server. A peer that
+// can't be dialed is backed off exponentially instead of being dialed
+// again on every RPC, and every call has a deadline, so a hung peer
+// can't hold up the caller.
+//
+// Usage: build the transport, hand it to MakeWithTransport, then call
+// Serve with the Raft it returned.
+
+// Defaults for TCPOptions fields left at zero.
+const (
+	defaultMaxIdleConns = 4
+	defaultDialTimeout  = 250 * time.Millisecond
+	defaultCallTimeout  = 600 * time.Millisecond
+	defaultMinBackoff   = 10 * time.Millisecond
+	defaultMaxBackoff   = 1 * time.Second
+)
+
+var (
+	errTransportClosed = errors.New("raft: transport closed")
+	errBackingOff      = errors.New("raft: peer unreachable, backing off")
+)
+
+type TCPOptions struct {
+	// Idle connections kept open to each peer.
+	MaxIdleConns int
+	DialTimeout  time.Duration
+	// Deadline for a single RPC, from sending it to getting the reply.
+	CallTimeout time.Duration
+	// Wait after the first failed dial to a peer, doubling with each
+	// failure after that up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+type TCPTransport struct {
+	addrs    []string
+	opts     TCPOptions
+	listener net.Listener
+	server   *rpc.Server
+	pools    []*connPool
+
+	mu       sync.Mutex
+	accepted map[net.Conn]bool
+	closed   bool
+}
+
+// Builds a transport for a cluster whose servers listen at addrs,
+// accepting requests for this server on listener (normally listening
+// on this server's entry in addrs). Taking a listener lets tests bind
+// several nodes to free localhost ports before any of them knows the
+// others' addresses.
+func MakeTCPTransport(listener net.Listener, addrs []string, opts TCPOptions) *TCPTransport {
+	if opts.MaxIdleConns == 0 {
+		opts.MaxIdleConns = defaultMaxIdleConns
+	}
+	if opts.DialTimeout == 0 {
+		opts.DialTimeout = defaultDialTimeout
+	}
+	if opts.CallTimeout == 0 {
+		opts.CallTimeout = defaultCallTimeout
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = defaultMinBackoff
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = defaultMaxBackoff
+	}
+
+	t := &TCPTransport{
+		addrs:    addrs,
+		opts:     opts,
+		listener: listener,
+		server:   rpc.NewServer(),
+		accepted: map[net.Conn]bool{},
+	}
+	for _, addr := range addrs {
+		t.pools = append(t.pools, &connPool{addr: addr, opts: &t.opts})
+	}
+	return t
+}
+
+// net/rpc wants handlers that return an error, the Raft handlers don't.
+type tcpService struct {
+	rf *Raft
+}
+
+func (s *tcpService) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	s.rf.RequestVote(args, reply)
+	return nil
+}
+
+func (s *tcpService) PreVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	s.rf.PreVote(args, reply)
+	return nil
+}
+
+func (s *tcpService) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	s.rf.AppendEntries(args, reply)
+	return nil
+}
+
+func (s *tcpService) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	s.rf.InstallSnapshot(args, reply)
+	return nil
+}
+
+func (s *tcpService) TimeoutNow(args *TimeoutNowArgs, reply *TimeoutNowReply) error {
+	s.rf.TimeoutNow(args, reply)
+	return nil
+}
+
+// Starts handing incoming requests to rf. Returns right away.
+func (t *TCPTransport) Serve(rf *Raft) error {
+	if err := t.server.RegisterName("Raft", &tcpService{rf: rf}); err != nil {
+		return err
+	}
+	go t.acceptLoop()
+	return nil
+}
+
+func (t *TCPTransport) acceptLoop() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			t.mu.Lock()
+			closed := t.closed
+			t.mu.Unlock()
+			if closed {
+				return
+			}
+			time.Sleep(t.opts.MinBackoff)
+			continue
+		}
+
+		t.mu.Lock()
+		if t.closed {
+			t.mu.Unlock()
+			conn.Close()
+			return
+		}
+		t.accepted[conn] = true
+		t.mu.Unlock()
+
+		go func() {
+			t.server.ServeConn(conn)
+			t.mu.Lock()
+			delete(t.accepted, conn)
+			t.mu.Unlock()
+		}()
+	}
+}
+
+// Stops listening and closes every connection, incoming and pooled.
+// Calls made afterwards fail.
+func (t *TCPTransport) Close() error {
+	t.mu.Lock()
+	t.closed = true
+	for conn := range t.accepted {
+		conn.Close()
+	}
+	t.mu.Unlock()
+
+	for _, pool := range t.pools {
+		pool.close()
+	}
+	return t.listener.Close()
+}
+
+func (t *TCPTransport) NumPeers() int {
+	return len(t.addrs)
+}
+
+func (t *TCPTransport) RequestVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	return t.call(server, "Raft.RequestVote", args, reply)
+}
+
+func (t *TCPTransport) PreVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool {
+	return t.call(server, "Raft.PreVote", args, reply)
+}
+
+func (t *TCPTransport) AppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool {
+	return t.call(server, "Raft.AppendEntries", args, reply)
+}
+
+func (t *TCPTransport) InstallSnapshot(server int, args *InstallSnapshotArgs, reply *InstallSnapshotReply) bool {
+	return t.call(server, "Raft.InstallSnapshot", args, reply)
+}
+
+func (t *TCPTransport) TimeoutNow(server int, args *TimeoutNowArgs, reply *TimeoutNowReply) bool {
+	return t.call(server, "Raft.TimeoutNow", args, reply)
+}
+
+// Sends one request over a pooled connection and waits up to
+// CallTimeout for the reply.
+func (t *TCPTransport) call(server int, method string, args interface{}, reply interface{}) bool {
+	pool := t.pools[server]
+	client, err := pool.get()
+	if err != nil {
+		return false
+	}
+
+	// Decode into a fresh reply, so one that shows up after we've given
+	// up can't race with the caller reading *reply.
+	result := reflect.New(reflect.TypeOf(reply).Elem())
+	call := client.Go(method, args, result.Interface(), make(chan *rpc.Call, 1))
+
+	timer := time.NewTimer(t.opts.CallTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.Done:
+		if call.Error != nil {
+			if _, ok := call.Error.(rpc.ServerError); ok {
+				// The connection itself is fine.
+				pool.put(client)
+			} else {
+				client.Close()
+				pool.failed()
+			}
+			return false
+		}
+		pool.put(client)
+		reflect.ValueOf(reply).Elem().Set(result.Elem())
+		return true
+	case <-timer.C:
+		// The peer may be hung with requests queued up behind this one,
+		// don't hand the connection to anyone else.
+		client.Close()
+		return false
+	}
+}
+
+// Connections to one peer.
+type connPool struct {
+	addr string
+	opts *TCPOptions
+
+	mu       sync.Mutex
+	idle     []*rpc.Client
+	failures int
+	retryAt  time.Time
+	closed   bool
+}
+
+// Returns an idle connection, or dials a new one unless the peer is
+// being backed off.
+func (p *connPool) get() (*rpc.Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, errTransportClosed
+	}
+	if len(p.idle) > 0 {
+		client := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+		p.mu.Unlock()
+		return client, nil
+	}
+	if time.Now().Before(p.retryAt) {
+		p.mu.Unlock()
+		return nil, errBackingOff
+	}
+	p.mu.Unlock()
+
+	conn, err := net.DialTimeout("tcp", p.addr, p.opts.DialTimeout)
+	if err != nil {
+		p.failed()
+		return nil, err
+	}
+	return rpc.NewClient(conn), nil
+}
+
+// Returns a connection that just completed a call.
+func (p *connPool) put(client *rpc.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures = 0
+	p.retryAt = time.Time{}
+	if p.closed || len(p.idle) >= p.opts.MaxIdleConns {
+		client.Close()
+		return
+	}
+	p.idle = append(p.idle, client)
+}
+
+// Records a failed dial or a broken connection. The idle connections
+// most likely broke the same way, so they're dropped too.
+func (p *connPool) failed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, client := range p.idle {
+		client.Close()
+	}
+	p.idle = nil
+
+	backoff := p.opts.MinBackoff
+	for i := 0; i < p.failures && backoff < p.opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.opts.MaxBackoff {
+		backoff = p.opts.MaxBackoff
+	}
+	p.retryAt = time.Now().Add(backoff)
+	p.failures++
+}
+
+func (p *connPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, client := range p.idle {
+		client.Close()
+	}
+	p.idle = nil
+}
@@ -0,0 +1,151 @@
+package raft
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Three peers on localhost ports in one process.
+func TestTCPTransportCluster(t *testing.T) {
+	const servers = 3
+	listeners := []net.Listener{}
+	addrs := []string{}
+	for i := 0; i < servers; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, listener)
+		addrs = append(addrs, listener.Addr().String())
+	}
+
+	var mu sync.Mutex
+	applied := make([]map[int]interface{}, servers)
+	rafts := make([]*Raft, servers)
+	transports := make([]*TCPTransport, servers)
+	for i := 0; i < servers; i++ {
+		applied[i] = map[int]interface{}{}
+		applyCh := make(chan ApplyMsg)
+		go func(i int) {
+			for msg := range applyCh {
+				if msg.CommandValid {
+					mu.Lock()
+					applied[i][msg.CommandIndex] = msg.Command
+					mu.Unlock()
+				}
+			}
+		}(i)
+		transports[i] = MakeTCPTransport(listeners[i], addrs, TCPOptions{})
+		rafts[i] = MakeWithTransport(transports[i], i, MakePersister(), applyCh)
+		if err := transports[i].Serve(rafts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func() {
+		for i := 0; i < servers; i++ {
+			rafts[i].Kill()
+			transports[i].Close()
+		}
+	}()
+
+	// Waits for a leader among the live peers and has every live peer
+	// apply command.
+	agree := func(command int, live []int) int {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			for _, i := range live {
+				index, _, isLeader := rafts[i].Start(command)
+				if !isLeader {
+					continue
+				}
+				for started := time.Now(); time.Since(started) < 2*time.Second; {
+					count := 0
+					mu.Lock()
+					for _, j := range live {
+						if applied[j][index] == command {
+							count++
+						}
+					}
+					mu.Unlock()
+					if count == len(live) {
+						return i
+					}
+					time.Sleep(20 * time.Millisecond)
+				}
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatalf("no agreement on %v", command)
+		return -1
+	}
+
+	leader := agree(1, []int{0, 1, 2})
+	agree(2, []int{0, 1, 2})
+
+	// The other two elect a new leader once it's gone.
+	rafts[leader].Kill()
+	transports[leader].Close()
+	live := []int{}
+	for i := 0; i < servers; i++ {
+		if i != leader {
+			live = append(live, i)
+		}
+	}
+	if newLeader := agree(3, live); newLeader == leader {
+		t.Fatalf("killed peer %v is still leading", leader)
+	}
+}
+
+func TestTCPConnPoolBackoff(t *testing.T) {
+	// A port with nothing listening on it.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	opts := TCPOptions{
+		MaxIdleConns: 1,
+		DialTimeout:  100 * time.Millisecond,
+		MinBackoff:   40 * time.Millisecond,
+		MaxBackoff:   200 * time.Millisecond,
+	}
+	pool := &connPool{addr: addr, opts: &opts}
+
+	// Each failure doubles the wait, up to MaxBackoff.
+	for _, want := range []time.Duration{40, 80, 160, 200, 200} {
+		want *= time.Millisecond
+		before := time.Now()
+		pool.failed()
+		pool.mu.Lock()
+		wait := pool.retryAt.Sub(before)
+		pool.mu.Unlock()
+		if wait < want || wait > want+20*time.Millisecond {
+			t.Fatalf("backoff %v after a failure, want %v", wait, want)
+		}
+	}
+
+	// No dialing until the backoff is over.
+	if _, err := pool.get(); err != errBackingOff {
+		t.Fatalf("get during backoff returned %v, want %v", err, errBackingOff)
+	}
+	time.Sleep(opts.MaxBackoff)
+	if _, err := pool.get(); err == nil || err == errBackingOff {
+		t.Fatalf("get after backoff returned %v, want a dial error", err)
+	}
+
+	// A successful call resets it.
+	server, client := net.Pipe()
+	defer server.Close()
+	pool.put(rpc.NewClient(client))
+	pool.mu.Lock()
+	failures, retryAt := pool.failures, pool.retryAt
+	pool.mu.Unlock()
+	if failures != 0 || !retryAt.IsZero() {
+		t.Fatalf("still backing off after a successful call")
+	}
+}
@@ -0,0 +1,122 @@
+package raft
+
+// Leadership transfer (Raft thesis §3.10): the leader stops accepting
+// new commands, brings the target's log up to date, then tells it to
+// start an election right away with a TimeoutNow RPC. The target wins
+// because its log is as up-to-date as anyone's, and the old leader
+// steps down when it sees the new term.
+
+type TimeoutNowArgs struct {
+	Term     int
+	LeaderId int
+}
+
+type TimeoutNowReply struct {
+	Term int
+}
+
+// Hands leadership to peers[target]. Returns nil once this server has
+// sent the target TimeoutNow and stepped down. Returns an error if it
+// isn't the leader, the target doesn't catch up within an election
+// timeout, no new term shows up within another one, or this server
+// loses leadership some other way (ErrLeadershipLost) before sending
+// TimeoutNow. If it is still the leader after an error, it goes back to
+// accepting Start().
+func (rf *Raft) TransferLeadership(target int) error {
+	rf.mu.Lock()
+	if rf.state != LeaderState || rf.transferring {
+		rf.mu.Unlock()
+		return ErrNotLeader
+	}
+	config, _ := rf.log.lastConfig()
+	if target < 0 || target >= rf.peers.NumPeers() || !config.contains(target) {
+		rf.mu.Unlock()
+		return ErrUnknownPeer
+	}
+	if target == rf.me {
+		rf.mu.Unlock()
+		return nil
+	}
+	rf.transferring = true
+	term := rf.currentTerm
+	rf.mu.Unlock()
+
+	defer func() {
+		rf.mu.Lock()
+		rf.transferring = false
+		rf.mu.Unlock()
+	}()
+
+	deadline := rf.clock.Now().Add(rf.conf.MaxElectionTimeout)
+	// maintainLogsLoop keeps calling sendLogUpdates for the target, wait
+	// for it to catch up. Start() is refused meanwhile, so the log can't
+	// grow underneath us.
+	for {
+		rf.mu.Lock()
+		stillLeader := rf.state == LeaderState && rf.currentTerm == term
+		caughtUp := rf.matchIndex[target] == rf.log.lastIndex()
+		rf.mu.Unlock()
+
+		if !stillLeader {
+			// Stepped down for some other reason, the target was never told.
+			return ErrLeadershipLost
+		}
+		if caughtUp {
+			break
+		}
+		if rf.killed() || rf.clock.Now().After(deadline) {
+			return ErrTransferTimeout
+		}
+		rf.clock.Sleep(rf.conf.PollInterval)
+	}
+
+	args := TimeoutNowArgs{Term: term, LeaderId: rf.me}
+	reply := TimeoutNowReply{}
+	rf.sendTimeoutNow(target, &args, &reply)
+
+	// Wait to hear about the target's new term.
+	deadline = rf.clock.Now().Add(rf.conf.MaxElectionTimeout)
+	for !rf.killed() && rf.clock.Now().Before(deadline) {
+		rf.mu.Lock()
+		if reply.Term > rf.currentTerm {
+			rf.updateTerm(reply.Term)
+			rf.revertToFollower()
+		}
+		steppedDown := rf.state != LeaderState || rf.currentTerm != term
+		rf.mu.Unlock()
+
+		if steppedDown {
+			return nil
+		}
+		rf.clock.Sleep(rf.conf.PollInterval)
+	}
+	return ErrTransferTimeout
+}
+
+// TimeoutNow RPC handler. The current leader has brought our log up to
+// date and wants us to start an election immediately, skipping the
+// election timeout (and pre-vote).
+func (rf *Raft) TimeoutNow(args *TimeoutNowArgs, reply *TimeoutNowReply) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if args.Term > rf.currentTerm {
+		rf.updateTerm(args.Term)
+		rf.revertToFollower()
+	}
+
+	// Always set reply to current term
+	reply.Term = rf.currentTerm
+
+	if args.Term < rf.currentTerm || rf.state == LeaderState {
+		return
+	}
+
+	rf.becomeCandidate()
+	go rf.beginElection(true)
+}
+
+func (rf *Raft) sendTimeoutNow(server int, args *TimeoutNowArgs, reply *TimeoutNowReply) bool {
+	ok := rf.peers.TimeoutNow(server, args, reply)
+	return ok
+}
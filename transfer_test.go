@@ -0,0 +1,64 @@
+package raft
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransferLeadership(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	target := (leader + 1) % 3
+	if err := c.rafts[leader].TransferLeadership(target); err != nil {
+		t.Fatalf("transfer failed: %v", err)
+	}
+	if newLeader := c.checkOneLeader(); newLeader != target {
+		t.Fatalf("leader is %v after transferring to %v", newLeader, target)
+	}
+	c.one(2, 3, true)
+}
+
+// A leader that steps down for another reason while the target is still
+// catching up must not report the transfer as done.
+func TestTransferLeadershipLost(t *testing.T) {
+	c := makeCluster(t, 3)
+	c.begin()
+	defer c.cleanup()
+
+	c.one(1, 3, false)
+	leader := c.checkOneLeader()
+	target := (leader + 1) % 3
+	c.disconnect(target)
+	c.one(2, 2, false)
+
+	rf := c.rafts[leader]
+	done := make(chan error)
+	go func() {
+		done <- rf.TransferLeadership(target)
+	}()
+
+	// A higher term shows up before the target has caught up.
+	for {
+		rf.mu.Lock()
+		transferring := rf.transferring
+		rf.mu.Unlock()
+		if transferring {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	rf.mu.Lock()
+	term := rf.currentTerm
+	rf.mu.Unlock()
+	args := RequestVoteArgs{Term: term + 1, CandidateId: (leader + 2) % 3}
+	reply := RequestVoteReply{}
+	rf.RequestVote(&args, &reply)
+
+	if err := <-done; err != ErrLeadershipLost {
+		t.Fatalf("transfer returned %v after losing leadership, want %v", err, ErrLeadershipLost)
+	}
+}
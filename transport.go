@@ -0,0 +1,21 @@
+package raft
+
+// Transport carries this server's RPCs to the other servers, named by
+// their index in the cluster (the same on every server). Each method
+// sends one request to server and fills in reply. It returns false if no
+// reply came back, because the server is down or unreachable or the
+// request or reply was lost. Like labrpc's Call(), every method must
+// return eventually, so callers don't add timeouts of their own.
+//
+// On the receiving side, a transport hands requests to the exported
+// handlers of the same name on that server's *Raft.
+type Transport interface {
+	// Number of servers in the cluster, this one included.
+	NumPeers() int
+
+	RequestVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool
+	PreVote(server int, args *RequestVoteArgs, reply *RequestVoteReply) bool
+	AppendEntries(server int, args *AppendEntriesArgs, reply *AppendEntriesReply) bool
+	InstallSnapshot(server int, args *InstallSnapshotArgs, reply *InstallSnapshotReply) bool
+	TimeoutNow(server int, args *TimeoutNowArgs, reply *TimeoutNowReply) bool
+}
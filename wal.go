@@ -0,0 +1,480 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"6.824/labgob"
+)
+
+// An append-only write-ahead log on disk, implementing LogStore.
+//
+// Entries go into segment files named after the index of their first
+// entry, and a new segment starts once the current one passes
+// SegmentSize. Each record is
+//
+//	length (4 bytes) | CRC-32C of payload (4 bytes) | payload
+//
+// where the payload is the gob-encoded index and entry. Truncating the
+// end of the log cuts the file short at the first record to go, and
+// truncating the front deletes whole segments.
+//
+// On open, a record at the end of the last segment that is cut short or
+// fails its CRC is taken to be a write torn by a crash and is dropped.
+// Anywhere else it means the disk is corrupt, and opening fails.
+
+type SyncPolicy int
+
+const (
+	// fsync after every write, before it returns.
+	SyncAlways SyncPolicy = iota
+	// fsync every SyncInterval in the background. A crash can lose the
+	// writes of the last interval.
+	SyncBatched
+	// Never fsync, leave it to the operating system.
+	SyncNone
+)
+
+const (
+	defaultSegmentSize  = 16 << 20
+	defaultSyncInterval = 10 * time.Millisecond
+	walRecordHeaderSize = 8
+	walSegmentSuffix    = ".wal"
+)
+
+var (
+	errWALClosed     = errors.New("raft: wal closed")
+	walChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type WALOptions struct {
+	// Size past which a new segment file is started.
+	SegmentSize int64
+	Sync        SyncPolicy
+	// How often SyncBatched flushes to disk.
+	SyncInterval time.Duration
+}
+
+type WAL struct {
+	mu       sync.Mutex
+	dir      string
+	opts     WALOptions
+	segments []*walSegment
+	// The last segment, open for appending
+	file   *os.File
+	dirty  bool
+	closed bool
+	done   chan bool
+}
+
+type walSegment struct {
+	first int
+	path  string
+	// Byte offset of each record, the i'th holding index first+i
+	offsets []int64
+	size    int64
+}
+
+func (s *walSegment) last() int {
+	return s.first + len(s.offsets) - 1
+}
+
+type walRecord struct {
+	Index int
+	Entry LogEntry
+}
+
+// Opens the write-ahead log in dir, creating it if needed, and recovers
+// whatever a previous run left there.
+func OpenWAL(dir string, opts WALOptions) (*WAL, error) {
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = defaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	w := &WAL{dir: dir, opts: opts, done: make(chan bool)}
+	if err := w.recover(); err != nil {
+		return nil, err
+	}
+	if len(w.segments) > 0 {
+		last := w.segments[len(w.segments)-1]
+		file, err := os.OpenFile(last.path, os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w.file = file
+	}
+
+	if opts.Sync == SyncBatched {
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// Finds the segment files in dir, checks them record by record and cuts
+// off a torn write at the end of the last one.
+func (w *WAL) recover() error {
+	names, err := filepath.Glob(filepath.Join(w.dir, "*"+walSegmentSuffix))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range names {
+		first, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(path), walSegmentSuffix))
+		if err != nil {
+			return fmt.Errorf("raft: wal: unexpected file %v", path)
+		}
+		w.segments = append(w.segments, &walSegment{first: first, path: path})
+	}
+	sort.Slice(w.segments, func(i, j int) bool { return w.segments[i].first < w.segments[j].first })
+
+	for i, segment := range w.segments {
+		_, offsets, valid, size, err := readSegment(segment.path, segment.first)
+		if err != nil {
+			return err
+		}
+		isLast := i == len(w.segments)-1
+		if valid < size {
+			if !isLast {
+				return fmt.Errorf("raft: wal: %v is corrupt at offset %v", segment.path, valid)
+			}
+			if err := w.cutTornWrite(segment.path, valid); err != nil {
+				return err
+			}
+		}
+		if i > 0 && segment.first != w.segments[i-1].last()+1 {
+			return fmt.Errorf("raft: wal: %v doesn't follow the segment before it", segment.path)
+		}
+		segment.offsets = offsets
+		segment.size = valid
+	}
+	return nil
+}
+
+// Cuts the segment at path down to size bytes. The cut is synced before
+// anything is appended after it, or a crash could bring the torn record
+// back in the middle of the log.
+func (w *WAL) cutTornWrite(path string, size int64) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+	if w.opts.Sync == SyncNone {
+		return nil
+	}
+	return file.Sync()
+}
+
+// Reads the records of a segment whose first entry is at first. valid is
+// how many bytes from the start hold intact records, size the length of
+// the file.
+func readSegment(path string, first int) (entries []LogEntry, offsets []int64, valid int64, size int64, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	for {
+		rest := data[valid:]
+		if len(rest) < walRecordHeaderSize {
+			break
+		}
+		length := int64(binary.LittleEndian.Uint32(rest[0:4]))
+		checksum := binary.LittleEndian.Uint32(rest[4:8])
+		if int64(len(rest)) < walRecordHeaderSize+length {
+			break
+		}
+		payload := rest[walRecordHeaderSize : walRecordHeaderSize+length]
+		if crc32.Checksum(payload, walChecksumTable) != checksum {
+			break
+		}
+
+		var record walRecord
+		if labgob.NewDecoder(bytes.NewBuffer(payload)).Decode(&record) != nil {
+			break
+		}
+		if record.Index != first+len(entries) {
+			return nil, nil, 0, 0, fmt.Errorf("raft: wal: %v holds index %v out of order", path, record.Index)
+		}
+
+		entries = append(entries, record.Entry)
+		offsets = append(offsets, valid)
+		valid += walRecordHeaderSize + length
+	}
+	return entries, offsets, valid, int64(len(data)), nil
+}
+
+func encodeRecord(index int, entry LogEntry) ([]byte, error) {
+	payload := new(bytes.Buffer)
+	if err := labgob.NewEncoder(payload).Encode(walRecord{Index: index, Entry: entry}); err != nil {
+		return nil, err
+	}
+
+	record := make([]byte, walRecordHeaderSize, walRecordHeaderSize+payload.Len())
+	binary.LittleEndian.PutUint32(record[0:4], uint32(payload.Len()))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(payload.Bytes(), walChecksumTable))
+	return append(record, payload.Bytes()...), nil
+}
+
+func (w *WAL) Append(index int, entries []LogEntry) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWALClosed
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if len(w.segments) > 0 {
+		last := w.segments[len(w.segments)-1].last()
+		if index <= last {
+			if err := w.truncateFrom(index); err != nil {
+				return err
+			}
+		} else if index > last+1 {
+			if err := w.truncateFrom(0); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i, entry := range entries {
+		if len(w.segments) == 0 || w.segments[len(w.segments)-1].size >= w.opts.SegmentSize {
+			if err := w.startSegment(index + i); err != nil {
+				return err
+			}
+		}
+
+		record, err := encodeRecord(index+i, entry)
+		if err != nil {
+			return err
+		}
+		segment := w.segments[len(w.segments)-1]
+		if _, err := w.file.WriteAt(record, segment.size); err != nil {
+			return err
+		}
+		segment.offsets = append(segment.offsets, segment.size)
+		segment.size += int64(len(record))
+	}
+	return w.wrote()
+}
+
+func (w *WAL) TruncateFrom(index int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWALClosed
+	}
+	if err := w.truncateFrom(index); err != nil {
+		return err
+	}
+	return w.wrote()
+}
+
+// Always call this while holding the wal lock.
+func (w *WAL) truncateFrom(index int) error {
+	for len(w.segments) > 0 {
+		segment := w.segments[len(w.segments)-1]
+		if segment.last() < index {
+			return nil
+		}
+		if segment.first < index {
+			keep := index - segment.first
+			if err := w.file.Truncate(segment.offsets[keep]); err != nil {
+				return err
+			}
+			segment.size = segment.offsets[keep]
+			segment.offsets = segment.offsets[:keep]
+			return nil
+		}
+
+		// The whole segment goes, the one before becomes the last.
+		w.file.Close()
+		w.file = nil
+		if err := os.Remove(segment.path); err != nil {
+			return err
+		}
+		// A segment coming back after a crash would put the entries
+		// we just truncated back in the log.
+		if w.opts.Sync == SyncAlways {
+			if err := syncDir(w.dir); err != nil {
+				return err
+			}
+		}
+		w.segments = w.segments[:len(w.segments)-1]
+		if len(w.segments) > 0 {
+			file, err := os.OpenFile(w.segments[len(w.segments)-1].path, os.O_WRONLY, 0644)
+			if err != nil {
+				return err
+			}
+			w.file = file
+		}
+	}
+	return nil
+}
+
+// Deletes the segments that only hold entries before index. The last
+// segment is always kept, so appending can carry on after it.
+func (w *WAL) TruncateBefore(index int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWALClosed
+	}
+	for len(w.segments) > 1 && w.segments[0].last() < index {
+		if err := os.Remove(w.segments[0].path); err != nil {
+			return err
+		}
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+func (w *WAL) Load() (int, []LogEntry, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, nil, errWALClosed
+	}
+	if len(w.segments) == 0 {
+		return 0, nil, nil
+	}
+
+	entries := []LogEntry{}
+	for _, segment := range w.segments {
+		segmentEntries, _, _, _, err := readSegment(segment.path, segment.first)
+		if err != nil {
+			return 0, nil, err
+		}
+		entries = append(entries, segmentEntries...)
+	}
+	return w.segments[0].first, entries, nil
+}
+
+// Makes every write so far durable without waiting for the next
+// SyncBatched flush. Does nothing under SyncNone.
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return errWALClosed
+	}
+	return w.sync()
+}
+
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+
+	err := w.sync()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+	}
+	return err
+}
+
+// Closes the current segment and starts a new one whose first entry
+// will be at index.
+// Always call this while holding the wal lock.
+func (w *WAL) startSegment(index int) error {
+	if w.file != nil {
+		if err := w.sync(); err != nil {
+			return err
+		}
+		w.file.Close()
+		w.file = nil
+	}
+
+	path := filepath.Join(w.dir, fmt.Sprintf("%020d%v", index, walSegmentSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.segments = append(w.segments, &walSegment{first: index, path: path})
+
+	// The new file's directory entry has to survive a crash too.
+	if w.opts.Sync == SyncAlways {
+		return syncDir(w.dir)
+	}
+	return nil
+}
+
+// Applies the sync policy after a write.
+// Always call this while holding the wal lock.
+func (w *WAL) wrote() error {
+	w.dirty = true
+	if w.opts.Sync == SyncAlways {
+		return w.sync()
+	}
+	return nil
+}
+
+// Always call this while holding the wal lock.
+func (w *WAL) sync() error {
+	if !w.dirty || w.file == nil || w.opts.Sync == SyncNone {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.dirty = false
+	return nil
+}
+
+func (w *WAL) syncLoop() {
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			w.sync()
+			w.mu.Unlock()
+		}
+	}
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
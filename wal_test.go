@@ -0,0 +1,317 @@
+package raft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"6.824/labrpc"
+)
+
+// A peer that kept its log store and stable store but lost its Persister
+// must still come back with the entries it acknowledged.
+func TestWALRestartWithoutPersister(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *Raft {
+		wal, err := OpenWAL(filepath.Join(dir, "wal"), WALOptions{Sync: SyncAlways})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stable, err := OpenFileStableStore(filepath.Join(dir, "stable"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Ends that go nowhere, the test plays the leader itself.
+		net := labrpc.MakeNetwork()
+		ends := make([]*labrpc.ClientEnd, 3)
+		for i := range ends {
+			ends[i] = net.MakeEnd(fmt.Sprint(i))
+		}
+		return MakeWithStores(MakeLabrpcTransport(ends), 1, MakePersister(), wal, stable, make(chan ApplyMsg, 10))
+	}
+
+	rf := open()
+	args := &AppendEntriesArgs{
+		Term:     100,
+		LeaderId: 0,
+		Entries:  []LogEntry{{Term: 100, Entry: 1}, {Term: 100, Entry: 2}, {Term: 100, Entry: 3}},
+	}
+	reply := &AppendEntriesReply{}
+	rf.AppendEntries(args, reply)
+	if !reply.Success {
+		t.Fatalf("follower rejected the entries: %+v", reply)
+	}
+	rf.Kill()
+
+	rf = open()
+	defer rf.Kill()
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if last := rf.log.lastIndex(); last != 3 {
+		t.Fatalf("log ends at %v after restart, want 3", last)
+	}
+	if rf.currentTerm < 100 {
+		t.Fatalf("term %v after restart, want at least 100", rf.currentTerm)
+	}
+}
+
+func openWAL(t *testing.T, dir string) *WAL {
+	t.Helper()
+	// Small segments, so a few entries span several files.
+	w, err := OpenWAL(dir, WALOptions{SegmentSize: 200, Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func walEntries(first, last int) []LogEntry {
+	entries := []LogEntry{}
+	for i := first; i <= last; i++ {
+		entries = append(entries, LogEntry{Term: 1 + i/10, Entry: i * 10})
+	}
+	return entries
+}
+
+// Checks the WAL holds exactly the entries from walEntries for
+// [first, last].
+func checkWAL(t *testing.T, w *WAL, first, last int) {
+	t.Helper()
+	loadedFirst, entries, err := w.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 && first > last {
+		return
+	}
+	if loadedFirst != first || len(entries) != last-first+1 {
+		t.Fatalf("wal holds [%v, %v], want [%v, %v]", loadedFirst, loadedFirst+len(entries)-1, first, last)
+	}
+	for i, entry := range entries {
+		if want := walEntries(first+i, first+i)[0]; entry != want {
+			t.Fatalf("index %v holds %v, want %v", first+i, entry, want)
+		}
+	}
+}
+
+func walSegments(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+walSegmentSuffix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestWALSegmentRollover(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir)
+	for i := 1; i <= 50; i += 5 {
+		if err := w.Append(i, walEntries(i, i+4)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(walSegments(t, dir)); n < 3 {
+		t.Fatalf("50 entries went into %v segments", n)
+	}
+	checkWAL(t, w, 1, 50)
+	w.Close()
+
+	w = openWAL(t, dir)
+	defer w.Close()
+	checkWAL(t, w, 1, 50)
+	if err := w.Append(51, walEntries(51, 60)); err != nil {
+		t.Fatal(err)
+	}
+	checkWAL(t, w, 1, 60)
+}
+
+func TestWALTruncateFrom(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir)
+	if err := w.Append(1, walEntries(1, 50)); err != nil {
+		t.Fatal(err)
+	}
+	segments := len(walSegments(t, dir))
+
+	// Across segment boundaries, then inside the last one.
+	if err := w.TruncateFrom(21); err != nil {
+		t.Fatal(err)
+	}
+	if len(walSegments(t, dir)) >= segments {
+		t.Fatalf("no segment removed truncating from 21")
+	}
+	checkWAL(t, w, 1, 20)
+	if err := w.TruncateFrom(20); err != nil {
+		t.Fatal(err)
+	}
+	checkWAL(t, w, 1, 19)
+
+	// Appending over existing entries replaces them.
+	if err := w.Append(15, []LogEntry{{Term: 9, Entry: "x"}}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	w = openWAL(t, dir)
+	defer w.Close()
+	first, entries, err := w.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != 1 || len(entries) != 15 || entries[14] != (LogEntry{Term: 9, Entry: "x"}) {
+		t.Fatalf("wal holds %v from %v after reopening", entries, first)
+	}
+
+	if err := w.TruncateFrom(0); err != nil {
+		t.Fatal(err)
+	}
+	checkWAL(t, w, 1, 0)
+	if len(walSegments(t, dir)) != 0 {
+		t.Fatalf("segments left after truncating everything")
+	}
+}
+
+func TestWALTruncateBefore(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir)
+	defer w.Close()
+	if err := w.Append(1, walEntries(1, 50)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only whole segments go, so the WAL may still start before 30.
+	if err := w.TruncateBefore(30); err != nil {
+		t.Fatal(err)
+	}
+	first, entries, err := w.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == 1 || first > 30 || first+len(entries)-1 != 50 {
+		t.Fatalf("wal holds [%v, %v] after truncating before 30", first, first+len(entries)-1)
+	}
+	checkWAL(t, w, first, 50)
+
+	// The last segment stays, even if all of it is before index.
+	if err := w.TruncateBefore(100); err != nil {
+		t.Fatal(err)
+	}
+	if len(walSegments(t, dir)) != 1 {
+		t.Fatalf("want only the last segment left")
+	}
+	first, _, _ = w.Load()
+	checkWAL(t, w, first, 50)
+	if err := w.Append(51, walEntries(51, 51)); err != nil {
+		t.Fatal(err)
+	}
+	checkWAL(t, w, first, 51)
+}
+
+func TestWALTornWrite(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir)
+	if err := w.Append(1, walEntries(1, 30)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// Cut the last record short, as a crash halfway through writing it
+	// would.
+	segments := walSegments(t, dir)
+	last := segments[len(segments)-1]
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(last, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	w = openWAL(t, dir)
+	checkWAL(t, w, 1, 29)
+	if err := w.Append(30, walEntries(30, 35)); err != nil {
+		t.Fatal(err)
+	}
+	checkWAL(t, w, 1, 35)
+	w.Close()
+
+	// Garbage after the last record is dropped too.
+	segments = walSegments(t, dir)
+	f, err := os.OpenFile(segments[len(segments)-1], os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9})
+	f.Close()
+
+	w = openWAL(t, dir)
+	defer w.Close()
+	checkWAL(t, w, 1, 35)
+}
+
+func TestWALCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+	w := openWAL(t, dir)
+	if err := w.Append(1, walEntries(1, 50)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	// Flip a payload byte of the first record in a segment that isn't
+	// the last, so its CRC no longer matches.
+	segments := walSegments(t, dir)
+	if len(segments) < 3 {
+		t.Fatalf("want at least 3 segments, got %v", len(segments))
+	}
+	middle := segments[1]
+	data, err := os.ReadFile(middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[walRecordHeaderSize+2] ^= 0xff
+	if err := os.WriteFile(middle, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if w, err := OpenWAL(dir, WALOptions{SegmentSize: 200}); err == nil {
+		w.Close()
+		t.Fatalf("opened a wal with a corrupt segment in the middle")
+	}
+	// Nothing was cut off trying.
+	info, err := os.Stat(middle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(data)) {
+		t.Fatalf("corrupt segment truncated to %v bytes", info.Size())
+	}
+}
+
+func TestWALRecoverBatched(t *testing.T) {
+	dir := t.TempDir()
+	w, err := OpenWAL(dir, WALOptions{SegmentSize: 200, Sync: SyncBatched, SyncInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 40; i += 10 {
+		if err := w.Append(i, walEntries(i, i+9)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.TruncateFrom(36); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Append(36, walEntries(36, 36)); err != errWALClosed {
+		t.Fatalf("append after close returned %v", err)
+	}
+
+	w = openWAL(t, dir)
+	defer w.Close()
+	checkWAL(t, w, 1, 35)
+}